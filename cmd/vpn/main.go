@@ -2,21 +2,54 @@ package main
 
 import (
 	"context"
+	"encoding/hex"
 	"flag"
+	"fmt"
+	"io"
 	"log"
-	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/Soyunomas/taltun/internal/config"
 	"github.com/Soyunomas/taltun/internal/engine"
+	"github.com/Soyunomas/taltun/pkg/crypto"
 )
 
 func main() {
+	// Subcomandos auxiliares (no arrancan el túnel)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "genpsk":
+			psk, err := crypto.GeneratePSK()
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			fmt.Println(hex.EncodeToString(psk[:]))
+			return
+		case "pubkey":
+			// Lee la clave privada (hex) por stdin, como `wg pubkey`.
+			in, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			priv, err := hex.DecodeString(strings.TrimSpace(string(in)))
+			if err != nil {
+				log.Fatalf("❌ Clave privada inválida: %v", err)
+			}
+			kp, err := crypto.NewKeyPairFromPrivate(priv)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			fmt.Println(hex.EncodeToString(kp.Public[:]))
+			return
+		}
+	}
+
 	pprofAddr := flag.String("pprof", "", "Habilitar pprof en address:port")
 
 	cfg, err := config.Load()
@@ -45,14 +78,8 @@ func main() {
 
 	peersAdded := 0
 	for _, p := range cfg.Peers {
-		vip := net.ParseIP(p.VIP)
-		if vip == nil {
-			log.Printf("⚠️ Peer VIP invalida ignorada: %s", p.VIP)
-			continue
-		}
-		
-		// Inyección de AllowedIPs
-		if err := srv.AddPeer(vip, p.Endpoint, p.AllowedIPs); err != nil {
+		// Registro del peer (Endpoint, AllowedIPs, nombre DNS)
+		if err := srv.AddPeer(p); err != nil {
 			log.Printf("⚠️ Error añadiendo peer %s: %v", p.VIP, err)
 		} else {
 			peersAdded++
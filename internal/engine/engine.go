@@ -3,6 +3,8 @@ package engine
 import (
 	"context"
 	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"net"
@@ -15,11 +17,15 @@ import (
 	"github.com/Soyunomas/taltun/internal/config"
 	"github.com/Soyunomas/taltun/internal/session"
 	"github.com/Soyunomas/taltun/pkg/cookie"
+	"github.com/Soyunomas/taltun/pkg/fragment"
+	"github.com/Soyunomas/taltun/pkg/meshdns"
 	"github.com/Soyunomas/taltun/pkg/crypto"
 	"github.com/Soyunomas/taltun/pkg/netutil"
 	"github.com/Soyunomas/taltun/pkg/pool"
+	"github.com/Soyunomas/taltun/pkg/qos"
 	"github.com/Soyunomas/taltun/pkg/protocol"
 	"github.com/Soyunomas/taltun/pkg/router"
+	"github.com/Soyunomas/taltun/pkg/transport"
 	
 	"golang.org/x/net/ipv4"
 	"golang.zx2c4.com/wireguard/tun"
@@ -36,6 +42,8 @@ type HandshakeRequest struct {
 	RemoteAddr *net.UDPAddr
 	Packet     []byte
 	ConnIndex  int
+	Conn       transport.Conn // nil si llegó por UDP
+	Enqueued   time.Time      // Para medir la latencia de la cola
 }
 
 // txRequest representa un paquete ya encriptado listo para enviar.
@@ -43,6 +51,12 @@ type txRequest struct {
 	Data []byte       // Slice sobre el buffer del pool
 	Buff *pool.Buff   // Puntero original para devolverlo al pool
 	Addr *net.UDPAddr // Destino
+
+	// Peer, clase y contador del nonce del paquete, para el planificador QoS
+	// (ver qos.go)
+	VIP   uint32
+	Class qos.Class
+	Seq   uint64
 }
 
 type TxBatch struct {
@@ -61,16 +75,29 @@ type PeerMap = map[uint32]*PeerInfo
 type Engine struct {
 	cfg   *config.Config
 	
-	ifce  tun.Device
+	tunQueues []tun.Device // Una por cola de la TUN (multi-queue)
+	netState *netutil.Tracker
+	dns      *netutil.DNSManager
+	meshDNS  *meshdns.Resolver
 	
 	pconns []*ipv4.PacketConn
 	rawConns []*net.UDPConn
+
+	// Transportes de flujo (TCP...): listeners y conexiones abiertas
+	listeners []transport.Listener
+	streamsMu sync.Mutex
+	streams   map[transport.Conn]*streamState
+	inbound   int // Conexiones entrantes en streams
 	
 	staticKey *crypto.KeyPair
 	localVIP  uint32
+	mac1Key   [32]byte // Clave de mac1 de los handshakes dirigidos a nosotros
+	cookieKey [32]byte // Clave con la que ciframos nuestros Cookie Reply
 
 	// Protection Modules
 	cookieProtector *cookie.Protector
+	rateLimiter     *cookie.RateLimiter
+	obfs            *protocol.Obfuscator // nil = sin ofuscación
 
 	// Routing & Peering
 	peers        atomic.Pointer[PeerMap]
@@ -78,9 +105,29 @@ type Engine struct {
 	peersWriteMu sync.Mutex
 
 	handshakeCh chan HandshakeRequest
+	hsMetrics   handshakeMetrics
 	txCh        chan *TxBatch
+
+	// Planificador QoS entre txCh y los sockets (nil = sin QoS: udpCh es txCh)
+	shaper *qos.Scheduler
+	udpCh  chan *TxBatch
 	
-	txCounter   uint64
+	// DNS anunciado por el hub (modo cliente): el ya aplicado y el pendiente de
+	// aplicar. dnsMu serializa los cambios del DNS del sistema con su
+	// restauración al cerrar.
+	pushedDNSMu sync.Mutex
+	pushedDNS   string
+	pendingDNS  dnsPush
+	dnsMu       sync.Mutex
+
+	// Reensamblado de MsgTypeFragment e id del próximo mensaje troceado
+	reassembler *fragment.Reassembler
+	fragID      uint32
+
+	// Offloads UDP disponibles en los sockets (ver udpoffload.go). GSO puede
+	// desactivarse en marcha si el Kernel lo rechaza.
+	udpGSO atomic.Bool
+	udpGRO bool
 	closed atomic.Bool
 }
 
@@ -98,35 +145,124 @@ func New(c *config.Config) (*Engine, error) {
 		cfg:             c,
 		staticKey:       kp,
 		localVIP:        myVIP,
+		mac1Key:         protocol.MAC1Key(kp.Public[:]),
+		cookieKey:       protocol.CookieKey(kp.Public[:]),
 		cookieProtector: cookie.NewProtector(),
+		rateLimiter:     cookie.NewRateLimiter(),
 		router:          router.New(),
+		netState:        netutil.NewTracker(c.TunName),
+		dns:             netutil.NewDNSManager(c.TunName),
 		handshakeCh:     make(chan HandshakeRequest, 500),
+		streams:         make(map[transport.Conn]*streamState),
 		txCh:            make(chan *TxBatch, 256), 
+		reassembler:     fragment.NewReassembler(),
+	}
+
+	e.udpCh = e.txCh
+	if c.QoS {
+		e.shaper = qos.NewScheduler()
+		e.udpCh = make(chan *TxBatch, qosOutBatches)
+	}
+
+	log.Printf("🔑 Clave pública local: %x", kp.Public)
+
+	if c.ObfuscationKey != "" {
+		e.obfs, err = protocol.NewObfuscator(c.ObfuscationKey, c.ObfuscationPadding)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Los buffers del dataplane deben admitir el mayor paquete cifrado del MTU
+	// y nunca menos de 2 KB: los handshakes (ML-KEM) se leen en los mismos.
+	if err := pool.SetDefaultSize(max(e.wireSize(c.MTU), pool.BufferSize)); err != nil {
+		return nil, fmt.Errorf("mtu %d: %v", c.MTU, err)
+	}
+	pool.SetDebug(c.PoolDebug)
+	if c.PoolDebug {
+		log.Printf("🧮 Pool en modo debug: buffers de %d bytes para MTU %d", pool.Size(), c.MTU)
 	}
 
 	initialPeers := make(PeerMap)
 	e.peers.Store(&initialPeers)
 
+	if c.MeshDNS {
+		// Sin upstreams configurados usamos los del sistema, leídos ahora, antes
+		// de que applyDNS pueda apuntar resolv.conf a nuestra propia VIP.
+		upstreams := c.MeshUpstreams
+		if len(upstreams) == 0 {
+			upstreams = meshdns.SystemUpstreams(c.LocalVIP)
+		}
+		e.meshDNS = meshdns.New(c.MeshDomain, upstreams)
+		if c.FullTunnel {
+			e.meshDNS.FwMark = c.FwMark
+		}
+		if c.Name != "" {
+			e.meshDNS.SetHost(c.Name, c.LocalVIP)
+		}
+	}
+
 	return e, nil
 }
 
-func (e *Engine) AddPeer(virtualIP net.IP, remoteAddr string, allowedIPs []string) error {
+func (e *Engine) AddPeer(pc config.PeerConfig) error {
+	virtualIP := net.ParseIP(pc.VIP)
 	vip := netutil.IPToUint32(virtualIP)
 	if vip == 0 {
-		return fmt.Errorf("ip virtual invalida")
+		return fmt.Errorf("ip virtual invalida: %s", pc.VIP)
 	}
+	remoteAddr, allowedIPs := pc.Endpoint, pc.AllowedIPs
 
 	var udpAddr *net.UDPAddr
 	var err error
 	
+	stream := pc.Transport != "" && pc.Transport != transport.UDP
+
 	if remoteAddr != "" {
 		udpAddr, err = net.ResolveUDPAddr("udp", remoteAddr)
-		if err != nil {
+		// Detrás de un proxy puede que no resolvamos el nombre localmente:
+		// lo resuelve el proxy al hacer CONNECT.
+		if err != nil && !stream {
 			return err
 		}
 	}
 
 	p := session.NewPeer(vip, udpAddr)
+	p.Transport = pc.Transport
+	p.Hub = remoteAddr != ""
+	if pc.PublicKey != "" {
+		pub, err := hex.DecodeString(pc.PublicKey)
+		if err != nil || len(pub) != crypto.KeySize {
+			return fmt.Errorf("public_key invalida para peer %s", pc.VIP)
+		}
+		p.PinStaticKey([crypto.KeySize]byte(pub))
+	}
+	if pc.PresharedKey != "" {
+		psk, err := hex.DecodeString(pc.PresharedKey)
+		if err != nil || len(psk) != crypto.KeySize {
+			return fmt.Errorf("preshared_key invalida para peer %s", pc.VIP)
+		}
+		p.PresharedKey = (*[crypto.KeySize]byte)(psk)
+	}
+	if stream {
+		p.DialAddr = remoteAddr
+		p.DialOptions = e.peerTransportOptions(pc)
+	}
+	if e.shaper != nil && (pc.RateLimit != "" || pc.Weight != 0) {
+		var rate uint64
+		if pc.RateLimit != "" {
+			if rate, err = qos.ParseRate(pc.RateLimit); err != nil {
+				return fmt.Errorf("rate_limit invalido para peer %s: %v", pc.VIP, err)
+			}
+		}
+		e.shaper.SetLimits(vip, qos.Limits{Rate: rate, Weight: pc.Weight})
+
+		limit := pc.RateLimit
+		if limit == "" {
+			limit = "ninguno"
+		}
+		log.Printf("🚦 QoS peer %s: límite %s, peso %d", virtualIP, limit, max(pc.Weight, 1))
+	}
 
 	e.peersWriteMu.Lock()
 	defer e.peersWriteMu.Unlock()
@@ -149,31 +285,91 @@ func (e *Engine) AddPeer(virtualIP net.IP, remoteAddr string, allowedIPs []strin
 		}
 	}
 
-	log.Printf("🔗 Peer Configurado: VIP=%s Endpoint=%v AllowedIPs=%d", virtualIP, remoteAddr, len(allowedIPs))
+	if pc.Name != "" && e.meshDNS != nil {
+		e.meshDNS.SetHost(pc.Name, virtualIP)
+	}
+
+	log.Printf("🔗 Peer Configurado: VIP=%s Endpoint=%v AllowedIPs=%d Transport=%s", virtualIP, remoteAddr, len(allowedIPs), transportName(p))
 	return nil
 }
 
 func (e *Engine) Initialize() error {
-	dev, err := tun.CreateTUN(e.cfg.TunName, e.cfg.MTU)
+	queues, err := createTUNQueues(e.cfg.TunName, e.cfg.MTU, e.cfg.TunQueues)
 	if err != nil {
 		return fmt.Errorf("error creando TUN: %v", err)
 	}
-	e.ifce = dev
+	e.tunQueues = queues
+
+	// Reconciliación: rutas de una ejecución anterior que murió sin limpiar.
+	if n, err := netutil.CleanupStale(e.cfg.TunName, e.cfg.RouteTable); err != nil {
+		log.Printf("⚠️ Error limpiando estado huérfano: %v", err)
+	} else if n > 0 {
+		log.Printf("🧹 Eliminadas %d rutas huérfanas de una ejecución anterior", n)
+	}
+	if err := netutil.RestoreResolvConf(); err != nil {
+		log.Printf("⚠️ Error restaurando resolv.conf de una ejecución anterior: %v", err)
+	}
 
-	ip := netutil.Uint32ToIP(e.localVIP)
-	log.Printf("🔧 Configurando Interfaz %s: IP=%s/24 MTU=%d", e.cfg.TunName, ip, e.cfg.MTU)
+	log.Printf("🔧 Configurando Interfaz %s: IP=%v MTU=%d Colas=%d", e.cfg.TunName, e.cfg.Addresses, e.cfg.MTU, len(queues))
+	if e.obfs != nil {
+		// IPv4 (20) + UDP (8) + cabecera + tag Poly1305 (16) + ofuscación
+		wire := e.cfg.MTU + 28 + protocol.HeaderSize + 16 + e.obfs.Overhead()
+		log.Printf("🎭 Ofuscación activa (padding máx. %d bytes)", e.cfg.ObfuscationPadding)
+		if wire > 1500 {
+			log.Printf("⚠️ Con ofuscación los paquetes pueden alcanzar %d bytes: considera mtu = %d", wire, e.cfg.MTU-(wire-1500))
+		}
+	}
 	
-	if err := netutil.AssignIP(e.cfg.TunName, ip); err != nil {
-		dev.Close()
+	if err := e.netState.AssignIP(e.cfg.Addresses); err != nil {
+		e.teardownNetwork()
+		e.closeTUN()
 		return fmt.Errorf("fallo asignando IP: %v", err)
 	}
 
-	if len(e.cfg.Routes) > 0 {
-		log.Printf("🛣️  Añadiendo rutas estáticas locales: %v", e.cfg.Routes)
-		if err := netutil.AddRoutes(e.cfg.TunName, e.cfg.Routes); err != nil {
-			dev.Close()
+	routes, defaults := e.cfg.Routes, []string(nil)
+	if e.cfg.FullTunnel {
+		routes, defaults = splitDefaultRoutes(e.cfg.Routes)
+		if len(defaults) == 0 {
+			defaults = []string{"0.0.0.0/0"}
+		}
+	}
+
+	if len(routes) > 0 {
+		log.Printf("🛣️  Añadiendo rutas estáticas locales: %v", routes)
+		skipped, err := e.netState.AddRoutes(routes)
+		if err != nil {
+			e.teardownNetwork()
+			e.closeTUN()
 			return fmt.Errorf("fallo añadiendo rutas: %v", err)
 		}
+		for _, r := range skipped {
+			log.Printf("ℹ️ Ruta %s ya existía en el sistema (no es de Taltun), se respeta", r)
+		}
+	}
+
+	var fwmark uint32
+	if e.cfg.FullTunnel {
+		fwmark = e.cfg.FwMark
+		log.Printf("🌐 Full Tunnel: %v -> tabla %d (fwmark 0x%x, kill-switch=%v)",
+			defaults, e.cfg.RouteTable, fwmark, e.cfg.KillSwitch)
+		policy := netutil.Policy{
+			FwMark:     fwmark,
+			Table:      e.cfg.RouteTable,
+			KillSwitch: e.cfg.KillSwitch,
+		}
+		if err := e.netState.EnableFullTunnel(defaults, policy); err != nil {
+			e.teardownNetwork()
+			e.closeTUN()
+			return fmt.Errorf("fallo configurando full tunnel: %v", err)
+		}
+	}
+
+	if len(e.cfg.DNS) > 0 {
+		if err := e.applyDNS(e.cfg.DNS, e.cfg.SearchDomains); err != nil {
+			e.teardownNetwork()
+			e.closeTUN()
+			return fmt.Errorf("fallo configurando DNS: %v", err)
+		}
 	}
 
 	numCPU := runtime.NumCPU()
@@ -183,13 +379,45 @@ func (e *Engine) Initialize() error {
 	log.Printf("⚙️ Inicializando %d sockets Batch UDP...", numCPU)
 
 	for i := 0; i < numCPU; i++ {
-		c, err := netutil.ListenUDPReusePort("udp", e.cfg.LocalAddr)
+		c, err := netutil.ListenUDPReusePort("udp", e.cfg.LocalAddr, fwmark)
 		if err != nil {
-			dev.Close()
+			e.teardownNetwork()
+			e.closeTUN()
 			return fmt.Errorf("error binding socket %d: %v", i, err)
 		}
 		e.rawConns[i] = c
 		e.pconns[i] = ipv4.NewPacketConn(c)
+
+		if e.cfg.PMTUDiscovery {
+			if err := netutil.SetDontFragment(c); err != nil {
+				log.Printf("⚠️ Socket %d sin DF (%v): las sondas de PMTU pueden dar de más", i, err)
+			}
+		}
+	}
+	if e.cfg.PMTUDiscovery {
+		log.Printf("📏 PMTU discovery activo: MTU interno entre %d y %d por peer", min(pmtuMinMTU, e.cfg.MTU), e.cfg.MTU)
+	}
+	if e.cfg.MSSClamp {
+		log.Printf("✂️ MSS clamping activo: SYN TCP ajustados al MTU de cada peer")
+	}
+	if e.shaper != nil {
+		log.Printf("🚦 QoS activo: prioridad por DSCP y reparto del enlace por peso entre peers")
+	}
+
+	// GSO/GRO solo si todos los sockets lo admiten (comparten los lotes).
+	gso, gro := true, true
+	for _, c := range e.rawConns {
+		g, r := netutil.EnableUDPOffload(c)
+		gso, gro = gso && g, gro && r
+	}
+	e.udpGSO.Store(gso)
+	e.udpGRO = gro
+	log.Printf("🚄 Offload UDP: GSO=%v GRO=%v", gso, gro)
+
+	if err := e.openListeners(); err != nil {
+		e.teardownNetwork()
+		e.closeTUN()
+		return fmt.Errorf("error abriendo transporte: %v", err)
 	}
 
 	return nil
@@ -202,11 +430,148 @@ func (e *Engine) Close() {
 	log.Println("🛑 Cerrando recursos (TUN/UDP)...")
 	
 	for _, c := range e.rawConns {
-		c.Close()
+		if c != nil {
+			c.Close()
+		}
 	}
+
+	e.closeListeners()
+
+	if e.meshDNS != nil {
+		e.meshDNS.Close()
+	}
+
+	// Rutas e IPs antes que la TUN: así el Kernel no las pierde a medias.
+	e.teardownNetwork()
 	
-	if e.ifce != nil {
-		e.ifce.Close()
+	e.closeTUN()
+}
+
+func (e *Engine) closeTUN() {
+	for _, q := range e.tunQueues {
+		q.Close()
+	}
+}
+
+// applyDNS configura el DNS del sistema para el túnel.
+func (e *Engine) applyDNS(servers []net.IP, search []string) error {
+	err := e.dns.Apply(netutil.DNSConfig{
+		Servers:      servers,
+		Search:       search,
+		DefaultRoute: e.cfg.FullTunnel,
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("🧭 DNS: %v (search=%v) vía %s", servers, search, e.dns.Backend())
+	return nil
+}
+
+// sendDNSConfig anuncia nuestro DNS a un peer (modo servidor).
+func (e *Engine) sendDNSConfig(p *PeerInfo) {
+	buf := pool.Get()
+	defer pool.Put(buf)
+
+	n, err := protocol.EncodeDNSConfig(buf.B, e.cfg.AdvertiseDNS, e.cfg.AdvertiseSearch)
+	if err != nil {
+		log.Printf("⚠️ Error codificando DNS para %s: %v", netutil.Uint32ToIP(p.VirtualIP), err)
+		return
+	}
+	e.sendControl(p, buf.B[:n])
+}
+
+// handleControl procesa un mensaje de control recibido de un peer autenticado.
+func (e *Engine) handleControl(p *PeerInfo, payload []byte) {
+	// Las sondas se responden siempre (aunque aquí no midamos): así el otro
+	// lado puede medir su camino.
+	if typ, id, _, ok := protocol.ParsePMTU(payload); ok {
+		e.handlePMTU(p, typ, id, len(payload))
+		return
+	}
+
+	// La configuración local manda sobre la anunciada, y solo se acepta la de
+	// un hub (peer al que nos conectamos por su endpoint).
+	if !e.cfg.AcceptDNS || len(e.cfg.DNS) > 0 || !p.Hub {
+		return
+	}
+
+	servers, domains, err := protocol.ParseDNSConfig(payload)
+	if err != nil || len(servers) == 0 {
+		return
+	}
+
+	key := fmt.Sprint(servers, domains)
+	e.pushedDNSMu.Lock()
+	defer e.pushedDNSMu.Unlock()
+	if key == e.pushedDNS || key == e.pendingDNS.key {
+		return
+	}
+
+	// Ejecuta procesos externos: fuera del worker RX.
+	e.pendingDNS = dnsPush{key: key, servers: servers, search: domains}
+	go e.applyPushedDNS()
+}
+
+// dnsPush es un DNS anunciado pendiente de aplicar.
+type dnsPush struct {
+	key     string
+	servers []net.IP
+	search  []string
+}
+
+// applyPushedDNS aplica el último DNS anunciado pendiente. Solo se da por
+// aplicado si lo logra: si falla, el siguiente anuncio (tras cada handshake)
+// lo reintenta. Bajo dnsMu, para no pisar la restauración de teardownNetwork.
+func (e *Engine) applyPushedDNS() {
+	e.dnsMu.Lock()
+	defer e.dnsMu.Unlock()
+
+	e.pushedDNSMu.Lock()
+	want := e.pendingDNS
+	e.pushedDNSMu.Unlock()
+	if want.key == "" || e.closed.Load() {
+		return
+	}
+
+	err := e.applyDNS(want.servers, want.search)
+	if err != nil {
+		log.Printf("⚠️ Error aplicando DNS anunciado: %v", err)
+	}
+
+	e.pushedDNSMu.Lock()
+	defer e.pushedDNSMu.Unlock()
+	if err == nil {
+		e.pushedDNS = want.key
+	}
+	if e.pendingDNS.key == want.key {
+		e.pendingDNS = dnsPush{}
+	}
+}
+
+// splitDefaultRoutes separa las rutas por defecto (/0), que en modo Full Tunnel
+// van a la tabla dedicada, del resto de rutas.
+func splitDefaultRoutes(all []string) (routes, defaults []string) {
+	for _, r := range all {
+		if netutil.IsDefaultRoute(r) {
+			defaults = append(defaults, r)
+		} else {
+			routes = append(routes, r)
+		}
+	}
+	return routes, defaults
+}
+
+// teardownNetwork retira del Kernel las rutas e IPs que instaló Initialize
+// y restaura el DNS del sistema.
+func (e *Engine) teardownNetwork() {
+	e.dnsMu.Lock()
+	err := e.dns.Restore()
+	e.dnsMu.Unlock()
+	if err != nil {
+		log.Printf("⚠️ Error restaurando DNS: %v", err)
+	}
+	if err := e.netState.Cleanup(); err != nil {
+		log.Printf("⚠️ Error restaurando la red: %v", err)
 	}
 }
 
@@ -223,16 +588,38 @@ func (e *Engine) Run(ctx context.Context) error {
 
 	go func() { errChan <- e.loopTunReadAndEncrypt() }()
 	go func() { errChan <- e.loopUdpBatchWrite() }()
+	if e.shaper != nil {
+		go e.loopQoS()
+	}
 	go func() { errChan <- e.housekeepingWorker(ctx) }() 
 	
-	go e.handshakeWorker()
+	workers := e.handshakeWorkers()
+	for i := 0; i < workers; i++ {
+		go e.handshakeWorker()
+	}
+
+	if e.meshDNS != nil {
+		addr := net.JoinHostPort(e.cfg.LocalVIP.String(), "53")
+		go func() {
+			if err := e.meshDNS.ListenAndServe(addr); err != nil {
+				log.Printf("⚠️ DNS de malla detenido: %v", err)
+			}
+		}()
+		log.Printf("🏷️  DNS de malla en %s (*.%s)", addr, strings.Trim(e.cfg.MeshDomain, "."))
+	}
 
-	log.Printf("🚀 Engine Running (ROUTING V2): %d Cores | VIP: %s", 
-		len(e.pconns), e.cfg.LocalVIP)
+	log.Printf("🚀 Engine Running (ROUTING V2): %d Cores | %d Handshake Workers | VIP: %s", 
+		len(e.pconns), workers, e.cfg.LocalVIP)
 	
+	for _, l := range e.listeners {
+		go e.acceptLoop(l)
+	}
+
 	currentPeers := *e.peers.Load()
 	for _, p := range currentPeers {
-		if p.GetEndpoint() != nil {
+		if p.UsesStream() {
+			go e.dialLoop(ctx, p) // Envía el handshake al conectar
+		} else if p.GetEndpoint() != nil {
 			go e.sendHandshakeInit(p)
 		}
 	}
@@ -255,11 +642,26 @@ func (e *Engine) housekeepingWorker(ctx context.Context) error {
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
 
+	var hsStats HandshakeStats
+	var fragStats fragment.Stats
+	var qosStats map[uint32]qos.FlowStats
+	lastReport := time.Now()
+
 	for {
 		select {
 		case <-ctx.Done():
 			return nil
-		case <-ticker.C:
+		case now := <-ticker.C:
+			if now.Sub(lastReport) >= handshakeStatsInterval {
+				hsStats = e.reportHandshakeStats(hsStats)
+				qosStats = e.reportQoSStats(qosStats)
+				e.reportPoolStats()
+				fragStats = e.reportFragmentStats(fragStats)
+				lastReport = now
+			}
+
+			e.reassembler.Expire(now)
+
 			currentPeers := *e.peers.Load()
 			for _, p := range currentPeers {
 				if p.NeedsRekey() {
@@ -269,11 +671,38 @@ func (e *Engine) housekeepingWorker(ctx context.Context) error {
 				if p.NeedsKeepalive() {
 					e.sendKeepalive(p)
 				}
+				if e.cfg.PMTUDiscovery {
+					e.probePMTU(p, now)
+				}
 			}
 		}
 	}
 }
 
+// sendControl envía un mensaje de control cifrado (mismo formato que Data).
+func (e *Engine) sendControl(p *PeerInfo, payload []byte) {
+	aead := p.GetAEAD()
+	endpoint := p.GetEndpoint()
+	if aead == nil || endpoint == nil {
+		return
+	}
+
+	pkt := pool.GetSize(e.wireSize(len(payload)))
+	defer pool.Put(pkt)
+
+	nonceBuf := make([]byte, protocol.NonceSize)
+	p.NextNonce(nonceBuf)
+
+	protocol.EncodeHeader(pkt.B, protocol.MsgTypeControl, e.localVIP, nonceBuf)
+
+	// La cabecera va autenticada: un Data no puede hacerse pasar por Control.
+	encrypted := aead.Seal(pkt.B[protocol.HeaderSize:protocol.HeaderSize], nonceBuf, payload, pkt.B[:protocol.HeaderSize])
+	totalLen := protocol.HeaderSize + len(encrypted)
+
+	e.sendPacket(p, pkt.B[:totalLen], endpoint)
+	p.UpdateTimestamps(false)
+}
+
 func (e *Engine) sendKeepalive(p *PeerInfo) {
 	aead := p.GetAEAD()
 	endpoint := p.GetEndpoint()
@@ -281,28 +710,27 @@ func (e *Engine) sendKeepalive(p *PeerInfo) {
 		return
 	}
 
-	pkt := pool.Get()
+	pkt := pool.GetSize(e.wireSize(0)) // Unos 50 bytes: no gasta un buffer de MTU
 	defer pool.Put(pkt)
 
 	nonceBuf := make([]byte, protocol.NonceSize)
-	copy(nonceBuf[0:4], []byte{0xCA, 0xFE, 0xBA, 0xBE})
-	ctr := atomic.AddUint64(&e.txCounter, 1)
-	binary.BigEndian.PutUint64(nonceBuf[4:], ctr)
+	p.NextNonce(nonceBuf)
 
-	protocol.EncodeDataHeader(pkt[:], e.localVIP, nonceBuf)
+	protocol.EncodeDataHeader(pkt.B, e.localVIP, nonceBuf)
 	
-	encrypted := aead.Seal(pkt[protocol.HeaderSize:protocol.HeaderSize], nonceBuf, nil, nil)
+	encrypted := aead.Seal(pkt.B[protocol.HeaderSize:protocol.HeaderSize], nonceBuf, nil, nil)
 	totalLen := protocol.HeaderSize + len(encrypted)
 
-	if len(e.rawConns) > 0 {
-		e.rawConns[0].WriteToUDP(pkt[:totalLen], endpoint)
-		p.UpdateTimestamps(false)
-	}
+	e.sendPacket(p, pkt.B[:totalLen], endpoint)
+	p.UpdateTimestamps(false)
 }
 
 // --- DATAPLANE RX (UDP -> TUN + RELAY) ---
 
 func (e *Engine) loopUdpBatchToTun(conn *ipv4.PacketConn, sockIdx int) error {
+	if e.udpGRO {
+		return e.loopUdpGROToTun(conn, sockIdx)
+	}
 	log.Printf("⚡ Batch RX Worker #%d iniciado", sockIdx)
 	
 	msgs := make([]ipv4.Message, BatchSize)
@@ -310,11 +738,12 @@ func (e *Engine) loopUdpBatchToTun(conn *ipv4.PacketConn, sockIdx int) error {
 	
 	for i := range msgs {
 		buffers[i] = pool.Get()
-		msgs[i].Buffers = [][]byte{buffers[i][:]}
+		msgs[i].Buffers = [][]byte{buffers[i].B}
 	}
 
 	var lastVIP uint32
 	var lastPeer *PeerInfo
+	tw := newTunWriter(e.tunQueue(sockIdx), e.cfg.Debug)
 
 	for {
 		nMsgs, err := conn.ReadBatch(msgs, 0)
@@ -329,17 +758,30 @@ func (e *Engine) loopUdpBatchToTun(conn *ipv4.PacketConn, sockIdx int) error {
 			msg := msgs[i]
 			n := msg.N
 			rAddr := msg.Addr.(*net.UDPAddr)
-			packet := buffers[i][:n]
+			packet := buffers[i].B[:n]
 
-			e.processOnePacket(packet, buffers[i], rAddr, sockIdx, &lastVIP, &lastPeer)
+			e.processOnePacket(packet, buffers[i], rAddr, sockIdx, nil, tw, &lastVIP, &lastPeer)
 			
 			buffers[i] = pool.Get()
-			msgs[i].Buffers[0] = buffers[i][:]
+			msgs[i].Buffers[0] = buffers[i].B
+		}
+		if tw != nil {
+			tw.flush()
 		}
 	}
 }
 
-func (e *Engine) processOnePacket(pkt []byte, originalBuff *pool.Buff, rAddr *net.UDPAddr, sockIdx int, lastVIP *uint32, lastPeer **PeerInfo) {
+// processOnePacket procesa un mensaje entrante. conn es la conexión de flujo
+// por la que llegó (nil si vino por el socket UDP sockIdx). tw acumula lo que
+// va a la TUN hasta que el worker haga flush (nil = escribir en el acto).
+func (e *Engine) processOnePacket(pkt []byte, originalBuff *pool.Buff, rAddr *net.UDPAddr, sockIdx int, conn transport.Conn, tw *tunWriter, lastVIP *uint32, lastPeer **PeerInfo) {
+	if e.obfs != nil {
+		var err error
+		if pkt, err = e.obfs.Unwrap(pkt); err != nil {
+			pool.Put(originalBuff) // Sin respuesta: ante un sondeo no existimos
+			return
+		}
+	}
 	if len(pkt) < 1 {
 		pool.Put(originalBuff) 
 		return
@@ -348,6 +790,13 @@ func (e *Engine) processOnePacket(pkt []byte, originalBuff *pool.Buff, rAddr *ne
 
 	// 1. Control Plane
 	if msgType == protocol.MsgTypeHandshakeInit || msgType == protocol.MsgTypeHandshakeResp {
+		// Presupuesto por origen antes de tocar el mensaje: un solo atacante no
+		// puede llenar handshakeCh (ni arrastrar a los demás al modo cookie) y
+		// tampoco usarnos para rebotar Cookie Replies sin límite.
+		if !e.rateLimiter.Allow(rAddr.IP) {
+			pool.Put(originalBuff)
+			return
+		}
 		underLoad := len(e.handshakeCh) > 250
 		
 		_, _, cookie, err := protocol.ParseHandshake(pkt)
@@ -356,7 +805,27 @@ func (e *Engine) processOnePacket(pkt []byte, originalBuff *pool.Buff, rAddr *ne
 			return
 		}
 
-		if underLoad {
+		if _, extended := protocol.ParseHandshakeExt(pkt); extended {
+			// mac1 siempre: sin nuestra clave pública no se llega a Curve25519.
+			if !protocol.VerifyMAC1(pkt, &e.mac1Key) {
+				pool.Put(originalBuff)
+				return
+			}
+			// Bajo carga, los Init necesitan además mac2 con la cookie de su IP.
+			if underLoad && msgType == protocol.MsgTypeHandshakeInit &&
+				!e.cookieProtector.ValidateMAC(rAddr.IP, func(c []byte) bool { return protocol.VerifyMAC2(pkt, c) }) {
+				replyCookie := e.cookieProtector.GenerateCookie(rAddr.IP)
+				e.sendCookieReply(rAddr, replyCookie, protocol.MAC1(pkt), sockIdx, conn)
+				pool.Put(originalBuff)
+				return
+			}
+		} else if e.cfg.MinProtocolVersion > protocol.ProtocolVersionLegacy {
+			// Sin extensión válida es legacy (v1) o está mal formado: no lo
+			// aceptaríamos, y sin mac1 no debe llegar a Curve25519.
+			pool.Put(originalBuff)
+			return
+		} else if underLoad {
+			// Handshake legacy (v1): cookie en claro en el mensaje.
 			validCookie := false
 			if len(cookie) > 0 {
 				if e.cookieProtector.ValidateCookie(rAddr.IP, cookie) {
@@ -366,7 +835,7 @@ func (e *Engine) processOnePacket(pkt []byte, originalBuff *pool.Buff, rAddr *ne
 
 			if !validCookie {
 				replyCookie := e.cookieProtector.GenerateCookie(rAddr.IP)
-				e.sendCookieReply(rAddr, replyCookie, sockIdx)
+				e.sendCookieReply(rAddr, replyCookie, nil, sockIdx, conn)
 				pool.Put(originalBuff)
 				return 
 			}
@@ -381,26 +850,26 @@ func (e *Engine) processOnePacket(pkt []byte, originalBuff *pool.Buff, rAddr *ne
 			RemoteAddr: rAddr, 
 			Packet: handshakePkt,
 			ConnIndex: sockIdx,
+			Conn: conn,
+			Enqueued: time.Now(),
 		}:
 		default:
+			e.hsMetrics.dropped.Add(1)
 		}
 		return
 
 	} else if msgType == protocol.MsgTypeCookieReply {
-		cookieBytes, err := protocol.ParseCookieReply(pkt)
-		if err == nil {
-			currentPeers := *e.peers.Load()
-			for _, p := range currentPeers {
-				ep := p.GetEndpoint()
-				if ep != nil && ep.IP.Equal(rAddr.IP) && ep.Port == rAddr.Port {
-					p.SetCookie(cookieBytes)
-					go e.sendHandshakeInit(p)
-					break
-				}
-			}
+		if p, cookieBytes := e.openCookieReply(pkt, rAddr, conn); p != nil {
+			p.SetCookie(cookieBytes)
+			go e.sendHandshakeInit(p)
 		}
 		pool.Put(originalBuff)
 		return
+
+	} else if msgType == protocol.MsgTypeFragment {
+		e.handleFragment(pkt, rAddr, sockIdx, conn, tw, lastVIP, lastPeer)
+		pool.Put(originalBuff)
+		return
 	}
 
 	// 2. Data Plane (Hot Path)
@@ -431,8 +900,14 @@ func (e *Engine) processOnePacket(pkt []byte, originalBuff *pool.Buff, rAddr *ne
 
 	plaintextBufPtr := pool.Get()
 	
+	// Los Control autentican su cabecera (ver sendControl)
+	var additionalData []byte
+	if msgType == protocol.MsgTypeControl {
+		additionalData = pkt[:protocol.HeaderSize]
+	}
+
 	// Abrir cifrado dejando Headroom para TUN (offset 16)
-	plaintext, err := peer.Open(plaintextBufPtr[TunHeadroom:TunHeadroom], nonce, ciphertext, nil)
+	plaintext, err := peer.Open(plaintextBufPtr.B[TunHeadroom:TunHeadroom], nonce, ciphertext, additionalData)
 	if err != nil {
 		pool.Put(plaintextBufPtr)
 		pool.Put(originalBuff)
@@ -461,10 +936,29 @@ func (e *Engine) processOnePacket(pkt []byte, originalBuff *pool.Buff, rAddr *ne
 		newEP := &net.UDPAddr{IP: make(net.IP, len(rAddr.IP)), Port: rAddr.Port}
 		copy(newEP.IP, rAddr.IP)
 		peer.SetEndpoint(newEP)
+		peer.RestartPMTU() // Camino nuevo: hay que volver a medir
 	}
 
 	peer.UpdateTimestamps(true) 
 
+	// Autenticado por una conexión de flujo: respondemos por ella.
+	if conn != nil && peer.GetStream() != conn {
+		peer.SetStream(conn)
+		e.streamAuthenticated(conn)
+	}
+
+	// El peer ya tiene la clave de sesión (nos ha enviado algo cifrado con ella):
+	// ahora sí puede descifrar la configuración anunciada.
+	if len(e.cfg.AdvertiseDNS) > 0 && peer.TakeConfigPending() {
+		go e.sendDNSConfig(peer)
+	}
+
+	if msgType == protocol.MsgTypeControl {
+		e.handleControl(peer, plaintext)
+		pool.Put(plaintextBufPtr)
+		return
+	}
+
 	if len(plaintext) == 0 {
 		pool.Put(plaintextBufPtr)
 		return
@@ -472,20 +966,29 @@ func (e *Engine) processOnePacket(pkt []byte, originalBuff *pool.Buff, rAddr *ne
 
 	atomic.AddUint64(&peer.BytesRx, uint64(len(plaintext)))
 
+	// Los SYN que nos llegan anuncian el MSS con el que les responderán los
+	// hosts locales: que quepa en el camino de vuelta.
+	if e.cfg.MSSClamp {
+		netutil.ClampMSS(plaintext, peer.PathMTU(e.cfg.MTU))
+	}
+
 	dstIP := netutil.ExtractDstIP(plaintext)
 	
 	// --- ENRUTAMIENTO CRÍTICO (Gateway / Site-to-Site Fix) ---
 
 	// 1. ¿Es para MÍ (VIP)? -> Aceptamos incondicionalmente.
 	if dstIP == e.localVIP {
-		writeToTun(e, plaintext, plaintextBufPtr)
+		writeToTun(e, sockIdx, tw, plaintext, plaintextBufPtr)
 		return
 	}
 
 	// 2. ¿Es para OTRO peer conocido en la malla? -> Relay.
 	targetPeer := e.router.Lookup(dstIP)
 	if targetPeer != nil {
-		e.sendRelay(plaintext, plaintextBufPtr, targetPeer)
+		if e.cfg.MSSClamp {
+			netutil.ClampMSS(plaintext, targetPeer.PathMTU(e.cfg.MTU))
+		}
+		e.sendRelay(plaintext, plaintextBufPtr, targetPeer, peer)
 		return
 	}
 
@@ -495,15 +998,26 @@ func (e *Engine) processOnePacket(pkt []byte, originalBuff *pool.Buff, rAddr *ne
 	// Lo escribimos en TUN y que el Kernel decida si lo enruta a la LAN.
 	
 	// Nota: Un filtro de seguridad extra aquí sería ideal, pero para V10.0 con esto basta.
-	writeToTun(e, plaintext, plaintextBufPtr)
+	writeToTun(e, sockIdx, tw, plaintext, plaintextBufPtr)
+}
+
+// tunQueue es la cola de la TUN en la que escribe el worker RX del socket
+// sockIdx (los transportes de flujo usan la 0).
+func (e *Engine) tunQueue(sockIdx int) tun.Device {
+	return e.tunQueues[sockIdx%len(e.tunQueues)]
 }
 
-func writeToTun(e *Engine, plaintext []byte, buff *pool.Buff) {
+func writeToTun(e *Engine, sockIdx int, tw *tunWriter, plaintext []byte, buff *pool.Buff) {
+	if tw != nil {
+		tw.add(plaintext, buff)
+		return
+	}
+
 	// Offset write para cabeceras TUN
 	packetLen := len(plaintext)
-	fullPacket := buff[:TunHeadroom+packetLen]
+	fullPacket := buff.B[:TunHeadroom+packetLen]
 
-	if _, err := e.ifce.Write([][]byte{fullPacket}, TunHeadroom); err != nil {
+	if _, err := e.tunQueue(sockIdx).Write([][]byte{fullPacket}, TunHeadroom); err != nil {
 		if e.cfg.Debug {
 			log.Printf("❌ TUN Write Error: %v", err)
 		}
@@ -511,7 +1025,9 @@ func writeToTun(e *Engine, plaintext []byte, buff *pool.Buff) {
 	pool.Put(buff)
 }
 
-func (e *Engine) sendRelay(plaintext []byte, buff *pool.Buff, peer *PeerInfo) {
+// sendRelay cifra plaintext hacia peer. from es el peer del que nos llegó el
+// paquete (nil = lo generamos nosotros, como los ICMP de relayTooBig).
+func (e *Engine) sendRelay(plaintext []byte, buff *pool.Buff, peer, from *PeerInfo) {
 	endpoint := peer.GetEndpoint()
 	aead := peer.GetAEAD()
 
@@ -520,18 +1036,28 @@ func (e *Engine) sendRelay(plaintext []byte, buff *pool.Buff, peer *PeerInfo) {
 		return
 	}
 
+	// Como en el camino de la TUN: lo que no cabe hacia el destino vuelve al
+	// origen como ICMP "no cabe" o, si no lo admite, sale troceado.
+	fragSize := 0
+	if mtu := peer.PathMTU(e.cfg.MTU); from != nil && len(plaintext) > mtu {
+		if e.relayTooBig(from, plaintext, mtu) {
+			pool.Put(buff)
+			return
+		}
+		fragSize = e.fragmentSize(peer, mtu)
+	}
+
 	outBufPtr := pool.Get()
-	outBuf := outBufPtr[:]
+	outBuf := outBufPtr.B
 	
 	offset := protocol.HeaderSize
 	
 	copy(outBuf[offset:], plaintext)
+	class := e.packetClass(plaintext)
 	pool.Put(buff)
 
 	nonceBuf := make([]byte, protocol.NonceSize) 
-	copy(nonceBuf[0:4], []byte{0xCA, 0xFE, 0xBA, 0xBE})
-	ctr := atomic.AddUint64(&e.txCounter, 1)
-	binary.BigEndian.PutUint64(nonceBuf[4:], ctr)
+	seq := peer.NextNonce(nonceBuf)
 
 	protocol.EncodeDataHeader(outBuf[:offset], e.localVIP, nonceBuf)
 
@@ -539,11 +1065,44 @@ func (e *Engine) sendRelay(plaintext []byte, buff *pool.Buff, peer *PeerInfo) {
 	totalLen := offset + len(encrypted)
 
 	atomic.AddUint64(&peer.BytesTx, uint64(len(encrypted)))
+
+	if fragSize > 0 && peer.GetStream() == nil {
+		frags := e.fragment(outBuf[:totalLen], fragSize, endpoint)
+		pool.Put(outBufPtr)
+		if frags == nil {
+			return
+		}
+		batch := txBatchPool.Get().(*TxBatch)
+		for i, req := range frags {
+			req.VIP, req.Class, req.Seq = peer.VirtualIP, class, seq
+			batch.Reqs[i] = req
+		}
+		batch.Len = len(frags)
+		e.sendBatchSafe(batch)
+		return
+	}
+
+	wire := e.wrap(outBuf[:totalLen])
+	if wire == nil {
+		pool.Put(outBufPtr)
+		return
+	}
+
+	if stream := peer.GetStream(); stream != nil || peer.UsesStream() {
+		if stream != nil {
+			stream.WritePacket(wire)
+		}
+		pool.Put(outBufPtr)
+		return
+	}
 	
 	req := txRequest{
-		Data: outBuf[:totalLen],
-		Buff: outBufPtr,
-		Addr: endpoint,
+		Data:  wire,
+		Buff:  outBufPtr,
+		Addr:  endpoint,
+		VIP:   peer.VirtualIP,
+		Class: class,
+		Seq:   seq,
 	}
 
 	newBatch := txBatchPool.Get().(*TxBatch)
@@ -564,7 +1123,29 @@ func (e *Engine) sendRelay(plaintext []byte, buff *pool.Buff, peer *PeerInfo) {
 // --- DATAPLANE TX SPLIT (TUN -> BATCH -> CHANNEL -> UDP) ---
 
 func (e *Engine) loopTunReadAndEncrypt() error {
-	const TunBatchSize = BatchSize 
+	// El cifrado va en paralelo (ver txpipeline.go); cada cola de la TUN tiene
+	// su lector, que solo enruta.
+	tp := e.startTxPipeline()
+
+	errCh := make(chan error, len(e.tunQueues))
+	for _, q := range e.tunQueues {
+		go func(dev tun.Device) { errCh <- e.readTunQueue(dev, tp) }(q)
+	}
+
+	for range e.tunQueues {
+		if err := <-errCh; err != nil {
+			// El engine se cae: el resto de lectores acaba con el Close.
+			return err
+		}
+	}
+	tp.stop()
+	return nil
+}
+
+// readTunQueue lee lotes de una cola de la TUN y los entrega al pipeline.
+func (e *Engine) readTunQueue(dev tun.Device, tp *txPipeline) error {
+	// Un super-paquete TSO se trocea en hasta tunReadBatch segmentos.
+	TunBatchSize := max(BatchSize, min(dev.BatchSize(), tunReadBatch))
 	
 	buffsPtrs := make([]*pool.Buff, TunBatchSize)
 	buffs := make([][]byte, TunBatchSize)
@@ -572,25 +1153,30 @@ func (e *Engine) loopTunReadAndEncrypt() error {
 
 	for i := 0; i < TunBatchSize; i++ {
 		buffsPtrs[i] = pool.Get()
-		buffs[i] = buffsPtrs[i][:]
+		buffs[i] = buffsPtrs[i].B
 	}
 	
 	offset := protocol.HeaderSize
 	var lastDstIP uint32
 	var lastPeer *PeerInfo
 
-	currentBatch := txBatchPool.Get().(*TxBatch)
-	currentBatch.Len = 0
-
 	for {
-		n, err := e.ifce.Read(buffs, sizes, offset)
-		if err != nil {
+		n, err := dev.Read(buffs, sizes, offset)
+		if errors.Is(err, tun.ErrTooManySegments) {
+			// Los n primeros segmentos son válidos; el resto se pierde y TCP
+			// lo retransmite.
+			if e.cfg.Debug {
+				log.Printf("⚠️ TUN: super-paquete con más de %d segmentos, truncado", TunBatchSize)
+			}
+		} else if err != nil {
 			if e.closed.Load() {
 				return nil
 			}
 			return fmt.Errorf("tun read error: %v", err)
 		}
 
+		job := txJobPool.Get().(*txJob)
+
 		for i := 0; i < n; i++ {
 			size := sizes[i]
 			if size == 0 {
@@ -632,44 +1218,44 @@ func (e *Engine) loopTunReadAndEncrypt() error {
 				continue
 			}
 
-			outBufPtr := pool.Get()
-			outBuf := outBufPtr[:]
-			copy(outBuf[offset:], packetData)
-
-			nonceBuf := make([]byte, protocol.NonceSize)
-			copy(nonceBuf[0:4], []byte{0xCA, 0xFE, 0xBA, 0xBE})
-
-			ctr := atomic.AddUint64(&e.txCounter, 1)
-			binary.BigEndian.PutUint64(nonceBuf[4:], ctr)
-			protocol.EncodeDataHeader(outBuf[:offset], e.localVIP, nonceBuf)
+			// No cabe en el camino hacia el peer: ICMP al origen para que
+			// baje su tamaño (ver pmtu.go) o, si no lo admite, troceado.
+			mtu := peer.PathMTU(e.cfg.MTU)
+			fragSize := 0
+			if size > mtu {
+				if e.packetTooBig(dev, packetData, mtu) {
+					continue
+				}
+				fragSize = e.fragmentSize(peer, mtu)
+			}
+			if e.cfg.MSSClamp {
+				netutil.ClampMSS(packetData, mtu)
+			}
 
-			encrypted := aead.Seal(outBuf[offset:offset], nonceBuf, outBuf[offset:offset+size], nil)
-			totalLen := offset + len(encrypted)
+			// El buffer leído pasa al pipeline tal cual (sin copia) y la TUN
+			// recibe uno nuevo para la siguiente lectura.
+			pkt := &job.pkts[job.n]
+			pkt.buff = buffsPtrs[i]
+			pkt.size = size
+			pkt.peer = peer
+			pkt.aead = aead
+			pkt.endpoint = endpoint
+			pkt.fragSize = fragSize
+			pkt.class = e.packetClass(packetData)
+			pkt.seq = peer.NextNonce(pkt.nonce[:])
+			job.n++
+
+			buffsPtrs[i] = pool.Get()
+			buffs[i] = buffsPtrs[i].B
 
-			atomic.AddUint64(&peer.BytesTx, uint64(len(encrypted)))
 			peer.UpdateTimestamps(false) 
-
-			req := txRequest{
-				Data: outBuf[:totalLen],
-				Buff: outBufPtr,
-				Addr: endpoint,
-			}
-			
-			currentBatch.Reqs[currentBatch.Len] = req
-			currentBatch.Len++
-
-			if currentBatch.Len == BatchSize {
-				e.sendBatchSafe(currentBatch)
-				currentBatch = txBatchPool.Get().(*TxBatch)
-				currentBatch.Len = 0
-			}
 		}
 
-		if currentBatch.Len > 0 {
-			e.sendBatchSafe(currentBatch)
-			currentBatch = txBatchPool.Get().(*TxBatch)
-			currentBatch.Len = 0
+		if job.n == 0 {
+			txJobPool.Put(job)
+			continue
 		}
+		tp.submit(job)
 	}
 }
 
@@ -690,10 +1276,14 @@ func (e *Engine) sendBatchSafe(batch *TxBatch) {
 
 func (e *Engine) loopUdpBatchWrite() error {
 	msgs := make([]ipv4.Message, BatchSize)
+	for i := range msgs {
+		msgs[i].Buffers = make([][]byte, 1)
+	}
+	gso := newGSOWriter()
 	var connIdx int
 
 	for {
-		batch := <-e.txCh
+		batch := <-e.udpCh
 		
 		count := batch.Len
 		if count == 0 {
@@ -701,15 +1291,24 @@ func (e *Engine) loopUdpBatchWrite() error {
 			continue
 		}
 
-		for i := 0; i < count; i++ {
-			msgs[i].Buffers = [][]byte{batch.Reqs[i].Data}
-			msgs[i].Addr = batch.Reqs[i].Addr
-		}
-
 		conn := e.pconns[connIdx]
 		connIdx = (connIdx + 1) % len(e.pconns)
 
-		n, err := conn.WriteBatch(msgs[:count], 0)
+		var n, sent int
+		var err error
+		if e.udpGSO.Load() {
+			// n cuenta mensajes (con GSO pueden agrupar varios paquetes)
+			n, err = e.writeBatchGSO(conn, gso, batch.Reqs[:count], msgs)
+			sent = count
+		} else {
+			for i := 0; i < count; i++ {
+				msgs[i].Buffers[0] = batch.Reqs[i].Data
+				msgs[i].Addr = batch.Reqs[i].Addr
+				msgs[i].OOB = nil
+			}
+			n, err = conn.WriteBatch(msgs[:count], 0)
+			sent = n
+		}
 		if err != nil {
 			if e.cfg.Debug {
 				log.Printf("writebatch error: %v", err)
@@ -717,7 +1316,7 @@ func (e *Engine) loopUdpBatchWrite() error {
 			if e.closed.Load() {
 				return nil
 			}
-		} else if n < count && e.cfg.Debug {
+		} else if sent < count && e.cfg.Debug {
 			log.Printf("⚠️ WriteBatch Parcial: %d/%d enviados", n, count)
 		}
 
@@ -725,7 +1324,10 @@ func (e *Engine) loopUdpBatchWrite() error {
 			pool.Put(batch.Reqs[i].Buff)
 			batch.Reqs[i].Buff = nil
 			batch.Reqs[i].Data = nil
-			msgs[i].Buffers = nil
+		}
+		for i := range msgs {
+			msgs[i].Buffers[0] = nil
+			msgs[i].OOB = nil
 		}
 
 		txBatchPool.Put(batch)
@@ -734,9 +1336,15 @@ func (e *Engine) loopUdpBatchWrite() error {
 
 // --- CONTROL PLANE ---
 
+// handshakeWorkers es el tamaño del pool que procesa handshakeCh.
+func (e *Engine) handshakeWorkers() int {
+	return max(1, e.cfg.HandshakeWorkers)
+}
+
 func (e *Engine) handshakeWorker() {
 	for req := range e.handshakeCh {
 		e.processHandshake(req)
+		e.hsMetrics.observe(time.Since(req.Enqueued))
 	}
 }
 
@@ -753,56 +1361,428 @@ func (e *Engine) processHandshake(req HandshakeRequest) {
 		return
 	}
 
+	// Los workers corren en paralelo, pero los handshakes de un mismo peer se
+	// procesan en serie.
+	peer.LockHandshake()
+	defer peer.UnlockHandshake()
+
+	if !peer.AcceptStatic(pubKey) {
+		log.Printf("⛔ Handshake de %s (%s) con una clave pública distinta de la conocida", netutil.Uint32ToIP(senderVIP), req.RemoteAddr)
+		return
+	}
+
+	msgType := req.Packet[0]
+
+	// Versión: un handshake sin extensión es de un nodo legacy (v1). Se
+	// negocia antes de Curve25519: lo que no aceptaríamos no cuesta nada.
+	ext, hasExt := protocol.ParseHandshakeExt(req.Packet)
+
+	remoteMin, remoteMax := protocol.ProtocolVersionLegacy, protocol.ProtocolVersionLegacy
+	if hasExt {
+		remoteMin, remoteMax = ext.MinVersion, ext.Version
+	}
+	version, ok := protocol.NegotiateVersion(e.cfg.MinProtocolVersion, protocol.CurrentProtocolVersion, remoteMin, remoteMax)
+	if !ok {
+		log.Printf("⛔ Peer %s (%s) incompatible: habla v%d-v%d, aceptamos v%d-v%d",
+			netutil.Uint32ToIP(senderVIP), req.RemoteAddr, remoteMin, remoteMax, e.cfg.MinProtocolVersion, protocol.CurrentProtocolVersion)
+		return
+	}
+	// Un peer que ya negoció v2 no vuelve a v1: quitar la extensión del Init es
+	// un downgrade que el KDF legacy, sin transcripción, no detecta.
+	if prev, _ := peer.Protocol(); version < prev {
+		log.Printf("⛔ Handshake v%d de %s (%s) rechazado: ya negoció v%d (¿downgrade?)",
+			version, netutil.Uint32ToIP(senderVIP), req.RemoteAddr, prev)
+		return
+	}
+
 	sharedSecret, err := e.staticKey.SharedSecret(pubKey)
 	if err != nil {
 		return
 	}
 
-	sessionAEAD, err := crypto.DeriveSessionKey(sharedSecret, "taltun-session-v1")
-	if err != nil {
+	// Con extensión el mensaje va firmado con nuestras claves estáticas y
+	// fechado: uno alterado o repetido se descarta antes de tocar la sesión
+	// o el endpoint.
+	if hasExt {
+		authKey, err := crypto.HandshakeAuthKey(sharedSecret, peer.PresharedKey)
+		if err != nil || !protocol.VerifyAuth(req.Packet, &authKey) {
+			if e.cfg.Debug {
+				log.Printf("⛔ Handshake de %s (%s) con autenticación inválida", netutil.Uint32ToIP(senderVIP), req.RemoteAddr)
+			}
+			return
+		}
+		if !peer.FreshTimestamp(ext.Timestamp) {
+			if e.cfg.Debug {
+				log.Printf("🔁 Handshake repetido de %s (%s) descartado", netutil.Uint32ToIP(senderVIP), req.RemoteAddr)
+			}
+			return
+		}
+	}
+
+	features := e.localFeatures() & ext.Features
+
+	suite, ok := e.negotiateCipher(msgType, ext, hasExt, features)
+	if !ok {
+		log.Printf("⛔ Peer %s eligió un cifrado no soportado (%d)", netutil.Uint32ToIP(senderVIP), ext.Cipher)
 		return
 	}
 
-	peer.SetSessionKey(sessionAEAD)
+	kemSecret, respExt, rekey, ok := e.negotiateSession(peer, msgType, pubKey, ext, hasExt, features)
+	if !ok {
+		return
+	}
+	if respExt != nil {
+		respExt.Cipher = uint8(suite)
+	}
+
+	// v2+: las extensiones de Init y Resp entran en el KDF.
+	var transcript []byte
+	if version > protocol.ProtocolVersionLegacy {
+		if msgType == protocol.MsgTypeHandshakeInit {
+			transcript = protocol.AppendTranscript(protocol.AppendTranscript(nil, &ext), respExt)
+		} else if init := peer.InitTranscript(); init != nil {
+			transcript = protocol.AppendTranscript(append([]byte(nil), init...), &ext)
+		} else {
+			return // Resp sin Init nuestro
+		}
+	}
+
+	if rekey {
+		send, recv, err := crypto.DeriveSessionKeys(sharedSecret, kemSecret, peer.PresharedKey, protocol.SessionContext(version), transcript, suite, msgType == protocol.MsgTypeHandshakeResp)
+		if err != nil {
+			return
+		}
+
+		peer.SetSessionKey(send, recv)
+		peer.SetProtocol(version, features)
+	}
+	if hasExt {
+		peer.AcceptTimestamp(ext.Timestamp)
+	}
+	peer.LearnStatic(pubKey)
 	peer.SetEndpoint(req.RemoteAddr)
+	// El transporte por el que llegó el handshake es por el que respondemos.
+	// Un peer configurado con transporte de flujo conserva su conexión saliente.
+	if req.Conn != nil || !peer.UsesStream() {
+		peer.SetStream(req.Conn)
+	}
+	if req.Conn != nil {
+		e.streamAuthenticated(req.Conn)
+	}
+	if len(e.cfg.AdvertiseDNS) > 0 {
+		peer.MarkConfigPending()
+	}
 	
-	log.Printf("🔐 Handshake Completado con %s (%s)", netutil.Uint32ToIP(senderVIP), req.RemoteAddr)
+	if rekey {
+		mode := "clásico"
+		if kemSecret != nil {
+			mode = "híbrido ML-KEM"
+		}
+		log.Printf("🔐 Handshake Completado con %s (%s, v%d, %s, %s)", netutil.Uint32ToIP(senderVIP), req.RemoteAddr, version, mode, suite)
+	}
 
-	if req.Packet[0] == protocol.MsgTypeHandshakeInit {
-		e.sendHandshakeResp(peer, req.RemoteAddr)
+	if msgType == protocol.MsgTypeHandshakeInit {
+		e.sendHandshakePacket(peer, e.localVIP, protocol.MsgTypeHandshakeResp, req.RemoteAddr, nil, respExt, pubKey)
 	}
 }
 
-func (e *Engine) sendHandshakeInit(p *PeerInfo) {
-	cookie := p.GetCookie()
-	e.sendHandshakePacket(e.localVIP, protocol.MsgTypeHandshakeInit, e.staticKey.Public[:], p.GetEndpoint(), cookie)
+// localFeatures son las features que anunciamos según la configuración.
+func (e *Engine) localFeatures() uint16 {
+	f := protocol.FeatureAESGCM | protocol.FeatureXChaCha | protocol.FeatureFragment
+	if e.cfg.PostQuantum != config.PostQuantumOff {
+		f |= protocol.FeatureHybridKEM
+	}
+	return f
+}
+
+// handshakeExt construye la extensión de nuestros handshakes.
+func (e *Engine) handshakeExt(random [protocol.RandomSize]byte, kem []byte) *protocol.HandshakeExt {
+	return &protocol.HandshakeExt{
+		Version:    protocol.CurrentProtocolVersion,
+		MinVersion: e.cfg.MinProtocolVersion,
+		Features:   e.localFeatures(),
+		Cipher:     uint8(e.cfg.CipherSuite),
+		Random:     random,
+		KEM:        kem,
+	}
 }
 
-func (e *Engine) sendHandshakeResp(p *PeerInfo, addr *net.UDPAddr) {
-	e.sendHandshakePacket(e.localVIP, protocol.MsgTypeHandshakeResp, e.staticKey.Public[:], addr, nil)
+// cipherFeature devuelve el bit de feature que anuncia soporte de una suite
+// (ChaCha20-Poly1305 es obligatoria y no tiene bit).
+func cipherFeature(s crypto.CipherSuite) (uint16, bool) {
+	switch s {
+	case crypto.CipherChaCha20Poly1305:
+		return 0, true
+	case crypto.CipherAES256GCM:
+		return protocol.FeatureAESGCM, true
+	case crypto.CipherXChaCha20Poly1305:
+		return protocol.FeatureXChaCha, true
+	}
+	return 0, false
 }
 
-func (e *Engine) sendHandshakePacket(senderVIP uint32, msgType uint8, pubKey []byte, addr *net.UDPAddr, cookie []byte) {
-	if addr == nil {
+// negotiateCipher elige la suite AEAD de la sesión. Responde quien recibe el
+// Init: la preferida del iniciador si ambos la soportan, si no la nuestra, y
+// ChaCha20-Poly1305 como último recurso (y con nodos legacy). El iniciador
+// acepta lo que diga el Resp.
+func (e *Engine) negotiateCipher(msgType uint8, ext protocol.HandshakeExt, hasExt bool, features uint16) (crypto.CipherSuite, bool) {
+	if !hasExt {
+		return crypto.CipherChaCha20Poly1305, true
+	}
+	remote := crypto.CipherSuite(ext.Cipher)
+
+	if msgType == protocol.MsgTypeHandshakeResp {
+		_, ok := cipherFeature(remote)
+		return remote, ok
+	}
+
+	for _, s := range []crypto.CipherSuite{remote, e.cfg.CipherSuite} {
+		if bit, ok := cipherFeature(s); ok && features&bit == bit {
+			return s, true
+		}
+	}
+	return crypto.CipherChaCha20Poly1305, true
+}
+
+// negotiateSession resuelve la parte efímera de un handshake v2+: randoms y,
+// si ambos lo soportan, ML-KEM. features es la intersección de las nuestras y
+// las del peer.
+//   - kemSecret: secreto a mezclar en el KDF (nil = sesión clásica).
+//   - respExt: extensión a enviar en el Resp (solo para un Init v2+).
+//   - rekey: false si es un reintento y la clave de sesión no cambia.
+//   - ok: false si hay que descartar el mensaje.
+func (e *Engine) negotiateSession(peer *PeerInfo, msgType uint8, pubKey []byte, ext protocol.HandshakeExt, hasExt bool, features uint16) (kemSecret []byte, respExt *protocol.HandshakeExt, rekey, ok bool) {
+	hybrid := features&protocol.FeatureHybridKEM != 0 && len(ext.KEM) > 0
+
+	if !hybrid && e.cfg.PostQuantum == config.PostQuantumRequire {
+		log.Printf("⛔ Handshake clásico rechazado de %s (post_quantum = require)", netutil.Uint32ToIP(peer.VirtualIP))
+		return nil, nil, false, false
+	}
+	// Legacy: sin randoms, la clave de sesión es siempre la misma.
+	if !hasExt {
+		return nil, nil, true, true
+	}
+	var kem []byte
+	if hybrid {
+		kem = ext.KEM
+	}
+
+	if msgType == protocol.MsgTypeHandshakeResp {
+		secret, err := peer.CompleteInit(ext.Random, kem)
+		if err != nil {
+			if e.cfg.Debug && err != session.ErrDuplicateResp {
+				log.Printf("⚠️ Resp descartado de %s: %v", netutil.Uint32ToIP(peer.VirtualIP), err)
+			}
+			return nil, nil, false, false
+		}
+		return secret, nil, true, true
+	}
+
+	// Init. Ambos lados han iniciado a la vez: solo uno debe responder, o cada
+	// uno acabaría con una clave distinta.
+	if peer.InitPending() {
+		if session.HandshakeWins(e.staticKey.Public[:], pubKey) {
+			return nil, nil, false, false // El peer responderá a nuestro Init
+		}
+		peer.AbandonInit()
+	}
+
+	random, secret, ct, dup, err := peer.RespondHandshake(ext.Random, kem)
+	if err != nil {
+		return nil, nil, false, false
+	}
+	return secret, e.handshakeExt(random, ct), !dup, true
+}
+
+func (e *Engine) sendHandshakeInit(p *PeerInfo) {
+	cookie := p.GetCookie()
+
+	// Sin su clave pública no podemos calcular mac1: solo cabe un Init legacy.
+	remote := p.RemoteStatic()
+	if remote == nil {
+		if e.cfg.MinProtocolVersion > protocol.ProtocolVersionLegacy {
+			if e.cfg.Debug {
+				log.Printf("⚠️ Sin public_key para %s: no se puede iniciar handshake", netutil.Uint32ToIP(p.VirtualIP))
+			}
+			return
+		}
+		e.sendHandshakePacket(p, e.localVIP, protocol.MsgTypeHandshakeInit, p.GetEndpoint(), cookie, nil, nil)
 		return
 	}
+
+	random, kemPub, err := p.InitiateHandshake(e.cfg.PostQuantum != config.PostQuantumOff)
+	if err != nil {
+		log.Printf("⚠️ Error preparando el Init (random / ML-KEM): %v", err)
+		return
+	}
+	ext := e.handshakeExt(random, kemPub)
+	p.SetInitTranscript(protocol.AppendTranscript(nil, ext))
+	e.sendHandshakePacket(p, e.localVIP, protocol.MsgTypeHandshakeInit, p.GetEndpoint(), cookie, ext, remote[:])
+}
+
+// sendHandshakePacket construye y envía un Init/Resp. Con ext (v2+) el mensaje
+// lleva mac1 (clave pública del receptor, remotePub) y mac2 (cookie). Sin ext
+// es un handshake legacy y la cookie va en claro.
+func (e *Engine) sendHandshakePacket(p *PeerInfo, senderVIP uint32, msgType uint8, addr *net.UDPAddr, cookie []byte, ext *protocol.HandshakeExt, remotePub []byte) {
 	pkt := pool.Get()
 	defer pool.Put(pkt)
 
-	n, _ := protocol.EncodeHandshake(pkt[:], msgType, senderVIP, pubKey, cookie)
+	pubKey := e.staticKey.Public[:]
+	var n int
+	var err error
+	if ext == nil {
+		n, err = protocol.EncodeHandshake(pkt.B, msgType, senderVIP, pubKey, cookie)
+	} else {
+		n, err = e.encodeHandshakeExt(pkt.B, p, msgType, senderVIP, ext, remotePub)
+		if err == nil {
+			mac1Key := protocol.MAC1Key(remotePub)
+			n, err = protocol.AppendMACs(pkt.B, n, &mac1Key, cookie)
+		}
+	}
+	if err != nil {
+		return
+	}
+	if ext != nil {
+		p.SetLastMAC1(protocol.MAC1(pkt.B[:n]))
+	}
 	
-	if len(e.rawConns) > 0 {
-		e.rawConns[0].WriteToUDP(pkt[:n], addr)
+	e.sendPacket(p, pkt.B[:n], addr)
+}
+
+// encodeHandshakeExt serializa un handshake v2+ fechado ahora y firmado con
+// la clave de autenticación que compartimos con el peer (sin los MACs).
+func (e *Engine) encodeHandshakeExt(dst []byte, p *PeerInfo, msgType uint8, senderVIP uint32, ext *protocol.HandshakeExt, remotePub []byte) (int, error) {
+	sharedSecret, err := e.staticKey.SharedSecret(remotePub)
+	if err != nil {
+		return 0, err
 	}
+	authKey, err := crypto.HandshakeAuthKey(sharedSecret, p.PresharedKey)
+	if err != nil {
+		return 0, err
+	}
+
+	stamped := *ext
+	stamped.Timestamp = protocol.TAI64N(time.Now())
+	n, err := protocol.EncodeHandshakeExt(dst, msgType, senderVIP, e.staticKey.Public[:], nil, &stamped)
+	if err != nil {
+		return 0, err
+	}
+	return protocol.AppendAuth(dst, n, &authKey)
+}
+
+// openCookieReply identifica el peer al que va dirigido un Cookie Reply y
+// devuelve la cookie. Los cifrados (v2+) solo se aceptan si se descifran con
+// la clave del peer y el mac1 de nuestro último handshake hacia él; los legacy
+// en claro, solo de peers sin clave pública (que aún hablan v1) y desde su
+// endpoint.
+func (e *Engine) openCookieReply(pkt []byte, rAddr *net.UDPAddr, conn transport.Conn) (*PeerInfo, []byte) {
+	currentPeers := *e.peers.Load()
+
+	switch len(pkt) {
+	case protocol.CookieReplySize:
+		for _, p := range currentPeers {
+			var cookieBytes []byte
+			opened := p.TakeLastMAC1(func(mac1 []byte) bool {
+				remote := p.RemoteStatic()
+				if remote == nil {
+					return false
+				}
+				key := protocol.CookieKey(remote[:])
+				var err error
+				cookieBytes, err = protocol.OpenCookieReply(pkt, &key, mac1)
+				return err == nil
+			})
+			if opened {
+				return p, cookieBytes
+			}
+		}
+
+	case protocol.LegacyCookieReplySize:
+		if e.cfg.MinProtocolVersion > protocol.ProtocolVersionLegacy {
+			return nil, nil
+		}
+		cookieBytes, err := protocol.ParseCookieReply(pkt)
+		if err != nil {
+			return nil, nil
+		}
+		for _, p := range currentPeers {
+			if p.RemoteStatic() != nil {
+				continue
+			}
+			ep := p.GetEndpoint()
+			fromPeer := ep != nil && ep.IP.Equal(rAddr.IP) && ep.Port == rAddr.Port
+			if conn != nil {
+				fromPeer = p.GetStream() == conn
+			}
+			if fromPeer {
+				return p, cookieBytes
+			}
+		}
+	}
+	return nil, nil
 }
 
-func (e *Engine) sendCookieReply(addr *net.UDPAddr, cookie []byte, sockIdx int) {
+// sendCookieReply responde a un handshake bajo carga con una cookie. Con mac1
+// (handshake v2+) va cifrada y ligada a ese mensaje; sin él, en claro (legacy).
+func (e *Engine) sendCookieReply(addr *net.UDPAddr, cookie []byte, mac1 []byte, sockIdx int, conn transport.Conn) {
 	pkt := pool.Get()
 	defer pool.Put(pkt)
 
-	n, _ := protocol.EncodeCookieReply(pkt[:], cookie)
+	var n int
+	var err error
+	if mac1 != nil {
+		n, err = protocol.SealCookieReply(pkt.B, &e.cookieKey, mac1, cookie)
+	} else {
+		n, err = protocol.EncodeCookieReply(pkt.B, cookie)
+	}
+	if err != nil {
+		return
+	}
+	wire := e.wrap(pkt.B[:n])
+	if wire == nil {
+		return
+	}
+
+	if conn != nil {
+		conn.WritePacket(wire)
+		return
+	}
 	
 	if sockIdx < len(e.rawConns) {
-		e.rawConns[sockIdx].WriteToUDP(pkt[:n], addr)
+		e.rawConns[sockIdx].WriteToUDP(wire, addr)
+	}
+}
+
+// wireSize es lo que ocupa en el cable un mensaje de datos o control con n
+// bytes de texto plano: cabecera + tag Poly1305 + ofuscación.
+func (e *Engine) wireSize(n int) int {
+	size := protocol.HeaderSize + n + 16
+	if e.obfs != nil {
+		size += e.obfs.Overhead()
+	}
+	return size
+}
+
+// wrap ofusca un mensaje en el sitio si la ofuscación está activa. pkt debe
+// venir de un buffer del pool (usa su capacidad para el salt y el padding).
+// Devuelve nil si no cabe.
+func (e *Engine) wrap(pkt []byte) []byte {
+	if e.obfs == nil {
+		return pkt
+	}
+	n, err := e.obfs.Wrap(pkt[:cap(pkt)], len(pkt))
+	if err != nil {
+		if e.cfg.Debug {
+			log.Printf("⚠️ DROP: %v", err)
+		}
+		return nil
+	}
+	return pkt[:n]
+}
+
+func transportName(p *PeerInfo) string {
+	if p.UsesStream() {
+		return p.Transport
 	}
+	return transport.UDP
 }
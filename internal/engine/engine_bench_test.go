@@ -0,0 +1,113 @@
+package engine
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/tun"
+
+	"github.com/Soyunomas/taltun/internal/config"
+	"github.com/Soyunomas/taltun/internal/session"
+	"github.com/Soyunomas/taltun/pkg/crypto"
+	"github.com/Soyunomas/taltun/pkg/pool"
+	"github.com/Soyunomas/taltun/pkg/router"
+)
+
+// benchTUN simula una TUN que entrega el mismo paquete IPv4 hasta agotar
+// remaining, y después se comporta como un dispositivo cerrado.
+type benchTUN struct {
+	pkt       []byte
+	remaining atomic.Int64
+	onClose   func()
+}
+
+func (t *benchTUN) Read(bufs [][]byte, sizes []int, offset int) (int, error) {
+	n := 0
+	for n < len(bufs) && t.remaining.Add(-1) >= 0 {
+		sizes[n] = copy(bufs[n][offset:], t.pkt)
+		n++
+	}
+	if n == 0 {
+		t.onClose()
+		return 0, os.ErrClosed
+	}
+	return n, nil
+}
+
+func (t *benchTUN) File() *os.File                   { return nil }
+func (t *benchTUN) Write([][]byte, int) (int, error) { return 0, errors.New("read-only") }
+func (t *benchTUN) MTU() (int, error)                { return 1420, nil }
+func (t *benchTUN) Name() (string, error)            { return "bench0", nil }
+func (t *benchTUN) Events() <-chan tun.Event         { return nil }
+func (t *benchTUN) Close() error                     { return nil }
+func (t *benchTUN) BatchSize() int                   { return BatchSize }
+
+// benchmarkTxPath mide el camino real TUN -> cifrado -> lote UDP
+// (loopTunReadAndEncrypt) con la suite indicada. El lado UDP se sustituye por
+// un consumidor que solo devuelve los buffers al pool.
+func benchmarkTxPath(b *testing.B, suite crypto.CipherSuite) {
+	const pktSize = 1400
+
+	pkt := make([]byte, pktSize)
+	pkt[0] = 0x45
+	copy(pkt[16:20], net.ParseIP("10.0.0.2").To4())
+
+	e := &Engine{
+		cfg:    &config.Config{},
+		router: router.New(),
+		txCh:   make(chan *TxBatch, 256),
+	}
+
+	peer := session.NewPeer(0x0A000002, &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 9000})
+	var shared [crypto.KeySize]byte
+	send, recv, err := crypto.DeriveSessionKeys(shared, nil, nil, "bench", nil, suite, false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	peer.SetSessionKey(send, recv)
+	if err := e.router.Insert("10.0.0.2/32", peer); err != nil {
+		b.Fatal(err)
+	}
+
+	dev := &benchTUN{pkt: pkt, onClose: func() { e.closed.Store(true) }}
+	dev.remaining.Store(int64(b.N))
+	e.tunQueues = []tun.Device{dev}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for batch := range e.txCh {
+			for i := 0; i < batch.Len; i++ {
+				pool.Put(batch.Reqs[i].Buff)
+			}
+			txBatchPool.Put(batch)
+		}
+	}()
+
+	b.SetBytes(pktSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	if err := e.loopTunReadAndEncrypt(); err != nil {
+		b.Fatal(err)
+	}
+
+	b.StopTimer()
+	close(e.txCh)
+	<-done
+}
+
+func BenchmarkTxChaCha20Poly1305(b *testing.B) {
+	benchmarkTxPath(b, crypto.CipherChaCha20Poly1305)
+}
+
+func BenchmarkTxAES256GCM(b *testing.B) {
+	benchmarkTxPath(b, crypto.CipherAES256GCM)
+}
+
+func BenchmarkTxXChaCha20Poly1305(b *testing.B) {
+	benchmarkTxPath(b, crypto.CipherXChaCha20Poly1305)
+}
@@ -0,0 +1,102 @@
+package engine
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/Soyunomas/taltun/pkg/pool"
+	"github.com/Soyunomas/taltun/pkg/protocol"
+	"github.com/Soyunomas/taltun/pkg/transport"
+)
+
+// --- FRAGMENTACIÓN PROPIA (MsgTypeFragment) ---
+//
+// Con fragmentation = true, un paquete de la TUN (o reenviado entre peers por
+// el hub) mayor que el MTU de su peer que no admite ICMP "no cabe" (IPv4 sin
+// DF) no sale en un datagrama que se
+// perdería: se cifra entero y el mensaje cifrado se reparte en varios
+// MsgTypeFragment del tamaño del camino. El receptor los reensambla
+// (pkg/fragment, con memoria acotada y caducidad) y descifra el resultado como
+// cualquier Data. Solo se fragmenta hacia peers que anunciaron
+// FeatureFragment en el handshake.
+
+// fragmentSize devuelve el tamaño de cada trozo hacia el peer (del mensaje
+// cifrado sin ofuscar), o 0 si no se fragmenta.
+func (e *Engine) fragmentSize(p *PeerInfo, mtu int) int {
+	// Los transportes de flujo no tienen MTU de camino: nunca se trocea hacia ellos.
+	if !e.cfg.Fragmentation || p.UsesStream() {
+		return 0
+	}
+	if _, features := p.Protocol(); features&protocol.FeatureFragment == 0 {
+		return 0
+	}
+	// Cada trozo ocupa en el cable lo mismo que un Data de mtu bytes.
+	return protocol.HeaderSize + mtu + 16 - protocol.FragmentHeaderSize
+}
+
+// fragment reparte el mensaje cifrado msg en trozos de hasta chunk bytes, ya
+// ofuscados y listos para el lote UDP. nil si no cabe en MaxFragments.
+func (e *Engine) fragment(msg []byte, chunk int, endpoint *net.UDPAddr) []txRequest {
+	count := (len(msg) + chunk - 1) / chunk
+	if count < 2 || count > protocol.MaxFragments {
+		return nil
+	}
+	per := (len(msg) + count - 1) / count // Trozos iguales (el último puede ser menor)
+	id := atomic.AddUint32(&e.fragID, 1)
+
+	reqs := make([]txRequest, 0, count)
+	for i := 0; i < count; i++ {
+		part := msg[i*per : min((i+1)*per, len(msg))]
+		n := protocol.FragmentHeaderSize + len(part)
+		size := n
+		if e.obfs != nil {
+			size += e.obfs.Overhead()
+		}
+
+		b := pool.GetSize(size)
+		protocol.EncodeFragmentHeader(b.B, e.localVIP, id, uint8(i), uint8(count))
+		copy(b.B[protocol.FragmentHeaderSize:], part)
+		wire := e.wrap(b.B[:n])
+		if wire == nil {
+			pool.Put(b)
+			continue
+		}
+		reqs = append(reqs, txRequest{Data: wire, Buff: b, Addr: endpoint})
+	}
+	return reqs
+}
+
+// handleFragment guarda un trozo y, si completa su mensaje, lo procesa como
+// si hubiera llegado entero. El llamante conserva (y devuelve) el buffer de pkt.
+func (e *Engine) handleFragment(pkt []byte, rAddr *net.UDPAddr, sockIdx int, conn transport.Conn, tw *tunWriter, lastVIP *uint32, lastPeer **PeerInfo) {
+	sender, id, index, count, data, err := protocol.ParseFragment(pkt)
+	if err != nil {
+		return
+	}
+
+	// Los trozos no se autentican hasta reensamblarlos: solo se guardan los de
+	// peers con sesión y desde su endpoint actual.
+	peer := (*e.peers.Load())[sender]
+	if peer == nil || peer.GetAEAD() == nil {
+		return
+	}
+	if conn == nil {
+		ep := peer.GetEndpoint()
+		if ep == nil || ep.Port != rAddr.Port || !ep.IP.Equal(rAddr.IP) {
+			return
+		}
+	}
+
+	buf, n, ok := e.reassembler.Add(sender, id, index, count, data, time.Now())
+	if !ok {
+		return
+	}
+	// Solo Data: nada de trozos dentro de trozos. Y debe caber en nuestros
+	// buffers al descifrarlo.
+	if buf.B[0] != protocol.MsgTypeData || n > pool.Size() {
+		pool.Put(buf)
+		return
+	}
+	e.processOnePacket(buf.B[:n], buf, rAddr, sockIdx, conn, tw, lastVIP, lastPeer)
+}
@@ -0,0 +1,58 @@
+package engine
+
+import (
+	"net"
+	"testing"
+
+	"github.com/Soyunomas/taltun/internal/config"
+	"github.com/Soyunomas/taltun/pkg/cookie"
+	"github.com/Soyunomas/taltun/pkg/crypto"
+	"github.com/Soyunomas/taltun/pkg/pool"
+	"github.com/Soyunomas/taltun/pkg/protocol"
+)
+
+func TestLegacyHandshakeDroppedWithV2Minimum(t *testing.T) {
+	kp, _ := crypto.GenerateKeyPair()
+	e := &Engine{
+		cfg:             &config.Config{MinProtocolVersion: protocol.ProtocolVersion2},
+		staticKey:       kp,
+		mac1Key:         protocol.MAC1Key(kp.Public[:]),
+		cookieProtector: cookie.NewProtector(),
+		rateLimiter:     cookie.NewRateLimiter(),
+		handshakeCh:     make(chan HandshakeRequest, 1),
+	}
+	from := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 9000}
+	sender, _ := crypto.GenerateKeyPair()
+
+	deliver := func(build func(b []byte) int) bool {
+		buf := pool.Get()
+		n := build(buf.B)
+		e.processOnePacket(buf.B[:n], buf, from, 0, nil, nil, new(uint32), new(*PeerInfo))
+		select {
+		case <-e.handshakeCh:
+			return true
+		default:
+			return false
+		}
+	}
+
+	// Init legacy (sin extensión ni mac1): no debe llegar a los workers.
+	if deliver(func(b []byte) int {
+		n, _ := protocol.EncodeHandshake(b, protocol.MsgTypeHandshakeInit, 0x0A000002, sender.Public[:], nil)
+		return n
+	}) {
+		t.Errorf("Legacy Init reached handshakeCh with min_protocol_version = 2")
+	}
+
+	// Uno extendido con mac1 válido sí (la autenticación es cosa del worker).
+	if !deliver(func(b []byte) int {
+		ext := &protocol.HandshakeExt{Version: protocol.CurrentProtocolVersion, MinVersion: protocol.ProtocolVersion2}
+		n, _ := protocol.EncodeHandshakeExt(b, protocol.MsgTypeHandshakeInit, 0x0A000002, sender.Public[:], nil, ext)
+		var authKey [32]byte
+		n, _ = protocol.AppendAuth(b, n, &authKey)
+		n, _ = protocol.AppendMACs(b, n, &e.mac1Key, nil)
+		return n
+	}) {
+		t.Errorf("Extended Init with valid mac1 was dropped")
+	}
+}
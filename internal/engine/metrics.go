@@ -0,0 +1,141 @@
+package engine
+
+import (
+	"log"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/Soyunomas/taltun/pkg/fragment"
+	"github.com/Soyunomas/taltun/pkg/pool"
+)
+
+// --- MÉTRICAS DEL PLANO DE CONTROL ---
+
+// handshakeStatsInterval es cada cuánto se resumen en el log (modo debug, o
+// siempre que se hayan descartado handshakes por cola llena).
+const handshakeStatsInterval = 30 * time.Second
+
+// HandshakeStats es una foto de la cola y los workers de handshake. Los
+// contadores son acumulados desde el arranque.
+type HandshakeStats struct {
+	Workers    int
+	QueueDepth int
+	QueueCap   int
+
+	Processed uint64
+	Dropped   uint64 // Descartados por cola llena
+
+	// Latencia = espera en cola + proceso (Curve25519, ML-KEM, respuesta).
+	// MaxLatency es la máxima desde el último informe del log.
+	TotalLatency time.Duration
+	MaxLatency   time.Duration
+}
+
+// AvgLatency es la latencia media por handshake procesado.
+func (s HandshakeStats) AvgLatency() time.Duration {
+	if s.Processed == 0 {
+		return 0
+	}
+	return s.TotalLatency / time.Duration(s.Processed)
+}
+
+type handshakeMetrics struct {
+	processed    atomic.Uint64
+	dropped      atomic.Uint64
+	totalLatency atomic.Int64
+	maxLatency   atomic.Int64 // Se reinicia en cada reportHandshakeStats
+}
+
+func (m *handshakeMetrics) observe(d time.Duration) {
+	m.processed.Add(1)
+	m.totalLatency.Add(int64(d))
+	for {
+		cur := m.maxLatency.Load()
+		if int64(d) <= cur || m.maxLatency.CompareAndSwap(cur, int64(d)) {
+			return
+		}
+	}
+}
+
+// HandshakeStats devuelve el estado actual de la cola de handshakes.
+func (e *Engine) HandshakeStats() HandshakeStats {
+	return HandshakeStats{
+		Workers:      e.handshakeWorkers(),
+		QueueDepth:   len(e.handshakeCh),
+		QueueCap:     cap(e.handshakeCh),
+		Processed:    e.hsMetrics.processed.Load(),
+		Dropped:      e.hsMetrics.dropped.Load(),
+		TotalLatency: time.Duration(e.hsMetrics.totalLatency.Load()),
+		MaxLatency:   time.Duration(e.hsMetrics.maxLatency.Load()),
+	}
+}
+
+// reportHandshakeStats resume en el log la actividad desde prev y devuelve la
+// foto actual para el siguiente intervalo.
+func (e *Engine) reportHandshakeStats(prev HandshakeStats) HandshakeStats {
+	cur := e.HandshakeStats()
+	// La máxima es del intervalo, como el resto de cifras del informe.
+	cur.MaxLatency = time.Duration(e.hsMetrics.maxLatency.Swap(0))
+	dropped := cur.Dropped - prev.Dropped
+	if !e.cfg.Debug && dropped == 0 {
+		return cur
+	}
+
+	window := HandshakeStats{
+		Processed:    cur.Processed - prev.Processed,
+		TotalLatency: cur.TotalLatency - prev.TotalLatency,
+	}
+	log.Printf("📊 Handshakes: cola %d/%d, %d workers, %d procesados, %d descartados, latencia media %v (máx %v)",
+		cur.QueueDepth, cur.QueueCap, cur.Workers, window.Processed, dropped, window.AvgLatency(), cur.MaxLatency)
+	return cur
+}
+
+// reportFragmentStats resume el reensamblado de MsgTypeFragment desde el
+// informe anterior (solo en modo debug y si hubo actividad).
+func (e *Engine) reportFragmentStats(prev fragment.Stats) fragment.Stats {
+	cur := e.reassembler.Stats()
+	if !e.cfg.Debug || cur == prev {
+		return cur
+	}
+	log.Printf("🧩 Fragmentos: %d reensamblados, %d caducados, %d descartados por memoria, %d pendientes",
+		cur.Reassembled-prev.Reassembled, cur.Expired-prev.Expired, cur.Evicted-prev.Evicted, cur.Pending)
+	return cur
+}
+
+// poolLeakAge: un buffer que lleva fuera más que esto se lista como posible
+// fuga. Los lectores de la TUN y de los sockets retienen siempre su lote, así
+// que lo que delata una fuga es una cuenta que crece de un informe a otro.
+const poolLeakAge = handshakeStatsInterval
+
+// poolLeakSites es cuántos orígenes (pilas de Get) se listan por informe.
+const poolLeakSites = 5
+
+// reportPoolStats resume en el log los buffers fuera del pool (solo con
+// pool_debug).
+func (e *Engine) reportPoolStats() {
+	if !pool.DebugEnabled() {
+		return
+	}
+	s := pool.GetStats()
+	log.Printf("🧮 Pool: fuera %s=%d %s=%d %s=%d %s=%d, dobles Put=%d",
+		pool.ClassSmall, s.Outstanding[pool.ClassSmall],
+		pool.ClassDefault, s.Outstanding[pool.ClassDefault],
+		pool.ClassJumbo, s.Outstanding[pool.ClassJumbo],
+		pool.ClassHuge, s.Outstanding[pool.ClassHuge],
+		s.DoublePuts)
+
+	// Agrupamos por origen: cientos de buffers suelen venir del mismo Get.
+	sites := make(map[string]int)
+	var order []string
+	for _, l := range pool.Leaks(poolLeakAge) {
+		if sites[l.Stack] == 0 {
+			order = append(order, l.Stack)
+		}
+		sites[l.Stack]++
+	}
+	sort.SliceStable(order, func(i, j int) bool { return sites[order[i]] > sites[order[j]] })
+	for _, stack := range order[:min(len(order), poolLeakSites)] {
+		log.Printf("🧮   %d buffers con más de %v: %s", sites[stack], poolLeakAge, stack)
+	}
+}
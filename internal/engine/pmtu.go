@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"log"
+	"time"
+
+	"golang.zx2c4.com/wireguard/tun"
+
+	"github.com/Soyunomas/taltun/pkg/netutil"
+	"github.com/Soyunomas/taltun/pkg/pool"
+	"github.com/Soyunomas/taltun/pkg/protocol"
+)
+
+// --- PATH MTU DISCOVERY ---
+//
+// Con pmtu_discovery los sockets UDP envían con DF y cada peer UDP mide su MTU
+// interno con sondas de control cifradas y rellenas hasta el tamaño a probar
+// (la búsqueda está en session/pmtu.go). Un paquete de la TUN mayor que el MTU
+// de su peer no sale: se devuelve a la TUN un ICMP "Fragmentation Needed" /
+// "Packet Too Big" y el Kernel baja el MTU de esa ruta, así que TCP se adapta
+// solo. Los peers que no responden a las sondas (versiones antiguas) siguen con
+// el MTU configurado.
+
+// pmtuMinMTU es el MTU interno que se da por alcanzable sin medirlo (el
+// mínimo de IPv6; por debajo la búsqueda no baja).
+const pmtuMinMTU = 1280
+
+// probePMTU envía la siguiente sonda al peer si toca. Los transportes de flujo
+// no tienen MTU de camino que medir.
+func (e *Engine) probePMTU(p *PeerInfo, now time.Time) {
+	if p.UsesStream() || p.GetStream() != nil || p.GetAEAD() == nil || p.GetEndpoint() == nil {
+		return
+	}
+
+	before := p.PathMTU(e.cfg.MTU)
+	id, size, ok := p.NextPMTUProbe(now, min(pmtuMinMTU, e.cfg.MTU), e.cfg.MTU)
+	e.logPMTUChange(p, before)
+	if !ok {
+		return
+	}
+
+	// La sonda debe ocupar en el cable al menos lo que un paquete de size
+	// bytes con el relleno de ofuscación máximo.
+	n := size
+	if e.obfs != nil {
+		n += e.obfs.Overhead() - protocol.ObfsOverhead
+	}
+	buf := pool.GetSize(n)
+	defer pool.Put(buf)
+	if _, err := protocol.EncodePMTUProbe(buf.B, id, n); err != nil {
+		return
+	}
+	e.sendControl(p, buf.B[:n])
+}
+
+// handlePMTU responde a una sonda o registra la respuesta a una nuestra.
+// msgLen es lo que ocupaba el mensaje de control recibido.
+func (e *Engine) handlePMTU(p *PeerInfo, typ uint8, id uint32, msgLen int) {
+	switch typ {
+	case protocol.ControlPMTUProbe:
+		var ack [8]byte
+		if n, err := protocol.EncodePMTUAck(ack[:], id, msgLen); err == nil {
+			e.sendControl(p, ack[:n])
+		}
+	case protocol.ControlPMTUAck:
+		before := p.PathMTU(e.cfg.MTU)
+		now := time.Now()
+		if p.AckPMTUProbe(id, now) {
+			e.logPMTUChange(p, before)
+			e.probePMTU(p, now) // Siguiente paso de la búsqueda sin esperar al housekeeping
+		}
+	}
+}
+
+func (e *Engine) logPMTUChange(p *PeerInfo, before int) {
+	if after := p.PathMTU(e.cfg.MTU); after != before {
+		log.Printf("📏 PMTU %s: MTU interno %d (antes %d)", netutil.Uint32ToIP(p.VirtualIP), after, before)
+	}
+}
+
+// relayTooBig devuelve cifrado al peer from el ICMP "no cabe" para pkt, un
+// paquete suyo que reenviamos y no cabe en el camino hacia su destino.
+// Devuelve false si pkt no admite ese error (como packetTooBig).
+func (e *Engine) relayTooBig(from *PeerInfo, pkt []byte, mtu int) bool {
+	buf := pool.Get()
+	n := netutil.PacketTooBig(buf.B, pkt, mtu)
+	if n == 0 {
+		pool.Put(buf)
+		return false
+	}
+	e.sendRelay(buf.B[:n], buf, from, nil)
+	return true
+}
+
+// packetTooBig escribe en la TUN el ICMP "no cabe" para pkt. Devuelve false si
+// pkt no admite ese error (p.ej. IPv4 sin DF): entonces sale tal cual.
+func (e *Engine) packetTooBig(dev tun.Device, pkt []byte, mtu int) bool {
+	buf := pool.Get()
+	defer pool.Put(buf)
+
+	n := netutil.PacketTooBig(buf.B[TunHeadroom:], pkt, mtu)
+	if n == 0 {
+		return false
+	}
+	if _, err := dev.Write([][]byte{buf.B[:TunHeadroom+n]}, TunHeadroom); err != nil && e.cfg.Debug {
+		log.Printf("❌ TUN Write Error (ICMP): %v", err)
+	}
+	return true
+}
@@ -0,0 +1,114 @@
+package engine
+
+import (
+	"log"
+	"time"
+
+	"github.com/Soyunomas/taltun/pkg/netutil"
+	"github.com/Soyunomas/taltun/pkg/qos"
+)
+
+// --- QOS: REPARTO DEL ENLACE ENTRE PEERS ---
+//
+// Sin QoS, txCh es una única cola FIFO hacia los sockets: un peer que satura
+// el enlace (un backup) llena la cola y los demás pierden paquetes. Con qos
+// activo, loopQoS se interpone entre txCh y loopUdpBatchWrite: vacía txCh en
+// las colas por peer del planificador (pkg/qos: token bucket + deficit round
+// robin por peso, clases por DSCP en prioridad estricta) y entrega al socket
+// solo lo que este puede tragar. Las colas llenas descartan del peer que las
+// llena. Los transportes de flujo y los mensajes de control no pasan por aquí.
+
+// qosOutBatches: lotes ya planificados a la espera del socket. Pocos, para
+// que la cola (y el reparto) se quede en el planificador.
+const qosOutBatches = 2
+
+// packetClass clasifica un paquete interno para el planificador.
+func (e *Engine) packetClass(pkt []byte) qos.Class {
+	if e.shaper == nil {
+		return qos.ClassNormal
+	}
+	return qos.ClassOf(pkt)
+}
+
+// loopQoS mueve los lotes de txCh al planificador y de este a udpCh.
+func (e *Engine) loopQoS() {
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	var out *TxBatch
+
+	for {
+		// Todo lo que espera en txCh entra antes de decidir el turno.
+		now := time.Now()
+		for n := len(e.txCh); n > 0; n-- {
+			e.enqueueQoS(<-e.txCh, now)
+		}
+
+		if out == nil {
+			out = txBatchPool.Get().(*TxBatch)
+			out.Len = 0
+		}
+		for out.Len < BatchSize {
+			pkt, ok := e.shaper.Dequeue(now)
+			if !ok {
+				break
+			}
+			out.Reqs[out.Len] = txRequest{Data: pkt.Data, Buff: pkt.Buff, Addr: pkt.Addr}
+			out.Len++
+		}
+
+		if out.Len > 0 {
+			// El socket marca el ritmo: mientras no acepta el lote se sigue
+			// encolando lo que llega.
+			select {
+			case e.udpCh <- out:
+				out = nil
+			case batch := <-e.txCh:
+				e.enqueueQoS(batch, time.Now())
+			}
+			continue
+		}
+
+		// Nada que enviar: esperar tráfico o a que algún bucket tenga tokens.
+		var release <-chan time.Time
+		if wait, ok := e.shaper.NextRelease(now); ok {
+			timer.Reset(wait)
+			release = timer.C
+		}
+		select {
+		case batch := <-e.txCh:
+			e.enqueueQoS(batch, time.Now())
+		case <-release:
+		}
+		timer.Stop()
+	}
+}
+
+// enqueueQoS reparte un lote de txCh en las colas de sus peers.
+func (e *Engine) enqueueQoS(batch *TxBatch, now time.Time) {
+	for i := 0; i < batch.Len; i++ {
+		r := &batch.Reqs[i]
+		e.shaper.Enqueue(r.VIP, r.Class, qos.Packet{Data: r.Data, Buff: r.Buff, Addr: r.Addr, Seq: r.Seq}, now)
+		*r = txRequest{}
+	}
+	batch.Len = 0
+	txBatchPool.Put(batch)
+}
+
+// reportQoSStats resume por peer los paquetes retenidos y descartados desde el
+// informe anterior (los descartes siempre; lo demás, en modo debug).
+func (e *Engine) reportQoSStats(prev map[uint32]qos.FlowStats) map[uint32]qos.FlowStats {
+	if e.shaper == nil {
+		return nil
+	}
+	cur := e.shaper.Stats()
+	for vip, s := range cur {
+		shaped := s.Shaped - prev[vip].Shaped
+		dropped := s.Dropped - prev[vip].Dropped
+		if dropped == 0 && (!e.cfg.Debug || shaped == 0) {
+			continue
+		}
+		log.Printf("🚦 QoS %s: %d paquetes retenidos por el límite, %d descartados, %d KB en cola",
+			netutil.Uint32ToIP(vip), shaped, dropped, s.Queued>>10)
+	}
+	return cur
+}
@@ -0,0 +1,124 @@
+package engine
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/Soyunomas/taltun/internal/config"
+	"github.com/Soyunomas/taltun/internal/session"
+	"github.com/Soyunomas/taltun/pkg/crypto"
+	"github.com/Soyunomas/taltun/pkg/pool"
+	"github.com/Soyunomas/taltun/pkg/protocol"
+)
+
+// relayPeer crea un spoke con sesión (clave legacy: la misma en ambos sentidos).
+func relayPeer(t *testing.T, vip uint32, port int) *PeerInfo {
+	p := session.NewPeer(vip, &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: port})
+	var shared [crypto.KeySize]byte
+	shared[0] = byte(vip)
+	send, recv, err := crypto.DeriveSessionKeys(shared, nil, nil, "relay-test", nil, crypto.CipherChaCha20Poly1305, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetSessionKey(send, recv)
+	return p
+}
+
+// relayPacket devuelve en un buffer del pool un paquete IPv4 de size bytes de
+// 10.0.0.2 a 10.0.0.3, con DF si df.
+func relayPacket(size int, df bool) (*pool.Buff, []byte) {
+	b := pool.Get()
+	pkt := b.B[:size]
+	clear(pkt)
+	pkt[0] = 0x45
+	binary.BigEndian.PutUint16(pkt[2:4], uint16(size))
+	if df {
+		pkt[6] = 0x40
+	}
+	pkt[8], pkt[9] = 64, 17
+	copy(pkt[12:16], net.IPv4(10, 0, 0, 2).To4())
+	copy(pkt[16:20], net.IPv4(10, 0, 0, 3).To4())
+	return b, pkt
+}
+
+func TestRelayTooBig(t *testing.T) {
+	e := &Engine{
+		cfg:      &config.Config{MTU: 1280},
+		localVIP: 0x0A000001,
+		txCh:     make(chan *TxBatch, 4),
+	}
+	from := relayPeer(t, 0x0A000002, 9002)
+	to := relayPeer(t, 0x0A000003, 9003)
+
+	// Cabe en el camino de A pero no en el de B: el hub devuelve a A el ICMP.
+	buf, pkt := relayPacket(1400, true)
+	e.sendRelay(pkt, buf, to, from)
+
+	batch := <-e.txCh
+	if batch.Len != 1 || batch.Reqs[0].VIP != from.VirtualIP {
+		t.Fatalf("Expected one message back to the origin, got %d to %x", batch.Len, batch.Reqs[0].VIP)
+	}
+	_, _, nonce, payload, err := protocol.ParseHeader(batch.Reqs[0].Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	icmp, err := from.Open(nil, nonce, payload, nil)
+	if err != nil {
+		t.Fatalf("ICMP not sealed for the origin: %v", err)
+	}
+	if icmp[9] != 1 || icmp[20] != 3 || icmp[21] != 4 || binary.BigEndian.Uint16(icmp[26:28]) != 1280 {
+		t.Errorf("Not a fragmentation needed for MTU 1280: % x", icmp[:28])
+	}
+	pool.Put(batch.Reqs[0].Buff)
+
+	// Lo que cabe sigue hacia su destino.
+	buf, pkt = relayPacket(1200, true)
+	e.sendRelay(pkt, buf, to, from)
+	if batch = <-e.txCh; batch.Reqs[0].VIP != to.VirtualIP {
+		t.Errorf("Packet within the MTU not relayed to its destination")
+	}
+	pool.Put(batch.Reqs[0].Buff)
+}
+
+func TestRelayFragments(t *testing.T) {
+	e := &Engine{
+		cfg:      &config.Config{MTU: 1280, Fragmentation: true},
+		localVIP: 0x0A000001,
+		txCh:     make(chan *TxBatch, 4),
+	}
+	from := relayPeer(t, 0x0A000002, 9002)
+	to := relayPeer(t, 0x0A000003, 9003)
+	to.SetProtocol(protocol.ProtocolVersion2, protocol.FeatureFragment)
+
+	// Sin DF no hay ICMP: hacia un peer con FeatureFragment sale troceado.
+	buf, pkt := relayPacket(1400, false)
+	e.sendRelay(pkt, buf, to, from)
+
+	batch := <-e.txCh
+	if batch.Len < 2 {
+		t.Fatalf("Oversized relay not fragmented: %d messages", batch.Len)
+	}
+	var msg []byte
+	for i := 0; i < batch.Len; i++ {
+		r := batch.Reqs[i]
+		if r.VIP != to.VirtualIP || len(r.Data) > protocol.HeaderSize+1280+16 {
+			t.Errorf("Fragment %d: VIP %x, %d bytes", i, r.VIP, len(r.Data))
+		}
+		_, _, index, count, data, err := protocol.ParseFragment(r.Data)
+		if err != nil || int(index) != i || int(count) != batch.Len {
+			t.Fatalf("Fragment %d: index %d/%d, %v", i, index, count, err)
+		}
+		msg = append(msg, data...)
+		pool.Put(r.Buff)
+	}
+
+	// Reensamblado es un Data que el destino abre entero.
+	_, _, nonce, payload, err := protocol.ParseHeader(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, err := to.Open(nil, nonce, payload, nil); err != nil || len(plain) != 1400 {
+		t.Errorf("Reassembled relay: %d bytes, %v", len(plain), err)
+	}
+}
@@ -0,0 +1,272 @@
+package engine
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/Soyunomas/taltun/internal/config"
+	"github.com/Soyunomas/taltun/internal/session"
+	"github.com/Soyunomas/taltun/pkg/netutil"
+	"github.com/Soyunomas/taltun/pkg/pool"
+	"github.com/Soyunomas/taltun/pkg/transport"
+)
+
+// --- TRANSPORTES DE FLUJO (TCP, WebSocket...) ---
+//
+// Los mensajes que llegan por un transporte de flujo entran en el mismo
+// processOnePacket que los de UDP; la única diferencia es que las respuestas
+// salen por la conexión (Peer.GetStream) en vez de por los sockets UDP.
+
+const (
+	dialBackoffMin = 1 * time.Second
+	dialBackoffMax = 30 * time.Second
+	dialTimeout    = 10 * time.Second
+
+	// Una conexión entrante tiene streamAuthTimeout para completar un
+	// handshake. Después, cualquiera se cierra tras streamIdleTimeout sin
+	// recibir nada (con sesión llegan keepalives cada KeepaliveTimeout).
+	streamAuthTimeout = 10 * time.Second
+	streamIdleTimeout = 6 * session.KeepaliveTimeout
+
+	// maxInboundStreams acota las conexiones entrantes abiertas a la vez.
+	maxInboundStreams = 1024
+
+	// Cada cuánto se renueva el deadline de lectura (no en cada mensaje).
+	streamDeadlineRefresh = time.Second
+)
+
+// streamState es el estado de una conexión de flujo abierta.
+type streamState struct {
+	inbound bool
+	authed  atomic.Bool // Ya llegó por ella un mensaje autenticado
+}
+
+func (e *Engine) transportOptions() transport.Options {
+	opts := transport.Options{}
+	if e.cfg.FullTunnel {
+		opts.FwMark = e.cfg.FwMark
+	}
+	return opts
+}
+
+// listenerOptions añade a transportOptions lo que necesita el lado servidor.
+func (e *Engine) listenerOptions() transport.Options {
+	opts := e.transportOptions()
+	opts.Path = e.cfg.WSPath
+	opts.TLSCert = e.cfg.TLSCert
+	opts.TLSKey = e.cfg.TLSKey
+	opts.TrustedProxies = e.cfg.WSTrustedProxies
+	return opts
+}
+
+// peerTransportOptions construye las opciones de marcado hacia un peer.
+func (e *Engine) peerTransportOptions(pc config.PeerConfig) transport.Options {
+	opts := e.transportOptions()
+	opts.Path = pc.WSPath
+	opts.Proxy = pc.Proxy
+	opts.TLSCAFile = pc.TLSCA
+	opts.TLSServerName = pc.TLSServerName
+	opts.TLSSkipVerify = pc.TLSSkipVerify
+	return opts
+}
+
+// openListeners abre los transportes de [interface.listen].
+func (e *Engine) openListeners() error {
+	for name, addr := range e.cfg.Listeners {
+		t, _ := transport.Get(name)
+		l, err := t.Listen(addr, e.listenerOptions())
+		if err != nil {
+			e.closeListeners()
+			return err
+		}
+		log.Printf("🔌 Escuchando transporte %s en %s", name, l.Addr())
+		e.listeners = append(e.listeners, l)
+	}
+	return nil
+}
+
+func (e *Engine) closeListeners() {
+	for _, l := range e.listeners {
+		l.Close()
+	}
+
+	e.streamsMu.Lock()
+	defer e.streamsMu.Unlock()
+	for c := range e.streams {
+		c.Close()
+	}
+}
+
+// streamAuthenticated marca que por c llegó un handshake o mensaje válido:
+// deja de aplicarse el plazo de autenticación.
+func (e *Engine) streamAuthenticated(c transport.Conn) {
+	e.streamsMu.Lock()
+	st := e.streams[c]
+	e.streamsMu.Unlock()
+	if st != nil {
+		st.authed.Store(true)
+	}
+}
+
+func (e *Engine) acceptLoop(l transport.Listener) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if !e.closed.Load() {
+				log.Printf("⚠️ Listener %s detenido: %v", l.Addr(), err)
+			}
+			return
+		}
+		if e.cfg.Debug {
+			log.Printf("🔌 Conexión entrante desde %s", c.RemoteAddr())
+		}
+		go e.readStream(c, nil)
+	}
+}
+
+// dialLoop mantiene abierta la conexión hacia un peer configurado con un
+// transporte de flujo, reconectando con backoff exponencial.
+func (e *Engine) dialLoop(ctx context.Context, p *PeerInfo) {
+	t, ok := transport.Get(p.Transport)
+	if !ok {
+		return
+	}
+	backoff := dialBackoffMin
+
+	for ctx.Err() == nil && !e.closed.Load() {
+		endpoint := p.DialAddr
+		if endpoint == "" {
+			return
+		}
+
+		dctx, cancel := context.WithTimeout(ctx, dialTimeout)
+		c, err := t.Dial(dctx, endpoint, p.DialOptions)
+		cancel()
+
+		if err != nil {
+			log.Printf("⚠️ No se pudo conectar por %s a %s: %v (reintento en %s)", p.Transport, endpoint, err, backoff)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, dialBackoffMax)
+			continue
+		}
+
+		log.Printf("🔌 Conectado por %s a %s (peer %s)", p.Transport, endpoint, netutil.Uint32ToIP(p.VirtualIP))
+		backoff = dialBackoffMin
+		p.SetStream(c)
+		e.sendHandshakeInit(p)
+
+		e.readStream(c, p) // Bloquea hasta que la conexión cae
+	}
+}
+
+// readStream lee mensajes de una conexión hasta que se cierra.
+// owner es el peer para el que se marcó la conexión (nil si es entrante:
+// se asocia al peer cuando este se autentica).
+func (e *Engine) readStream(c transport.Conn, owner *PeerInfo) {
+	st := &streamState{inbound: owner == nil}
+	e.streamsMu.Lock()
+	if st.inbound && e.inbound >= maxInboundStreams {
+		e.streamsMu.Unlock()
+		c.Close()
+		if e.cfg.Debug {
+			log.Printf("🔌 Conexión %s rechazada: %d entrantes abiertas", c.RemoteAddr(), maxInboundStreams)
+		}
+		return
+	}
+	e.streams[c] = st
+	if st.inbound {
+		e.inbound++
+	}
+	e.streamsMu.Unlock()
+
+	defer func() {
+		c.Close()
+		e.streamsMu.Lock()
+		delete(e.streams, c)
+		if st.inbound {
+			e.inbound--
+		}
+		e.streamsMu.Unlock()
+
+		if owner != nil {
+			owner.ClearStream(c)
+		}
+		// Un peer entrante puede haber quedado atado a esta conexión.
+		for _, p := range *e.peers.Load() {
+			p.ClearStream(c)
+		}
+	}()
+
+	rAddr := streamUDPAddr(c.RemoteAddr())
+
+	var lastVIP uint32
+	var lastPeer *PeerInfo
+
+	// Hasta autenticarse, una conexión entrante tiene un plazo fijo.
+	authDeadline := time.Now().Add(streamAuthTimeout)
+	var refreshed time.Time
+
+	for {
+		if !st.inbound || st.authed.Load() {
+			if now := time.Now(); now.Sub(refreshed) >= streamDeadlineRefresh {
+				c.SetReadDeadline(now.Add(streamIdleTimeout))
+				refreshed = now
+			}
+		} else {
+			c.SetReadDeadline(authDeadline)
+		}
+
+		buf := pool.Get()
+		n, err := c.ReadPacket(buf.B)
+		if err != nil {
+			pool.Put(buf)
+			if e.cfg.Debug && !e.closed.Load() {
+				log.Printf("🔌 Conexión %s cerrada: %v", c.RemoteAddr(), err)
+			}
+			return
+		}
+		e.processOnePacket(buf.B[:n], buf, rAddr, 0, c, nil, &lastVIP, &lastPeer)
+	}
+}
+
+// sendPacket envía un mensaje ya construido a un peer por su transporte.
+// Los peers configurados con un transporte de flujo nunca caen a UDP.
+func (e *Engine) sendPacket(p *PeerInfo, pkt []byte, addr *net.UDPAddr) {
+	if pkt = e.wrap(pkt); pkt == nil {
+		return
+	}
+	if c := p.GetStream(); c != nil {
+		c.WritePacket(pkt)
+		return
+	}
+	if p.UsesStream() || addr == nil {
+		return
+	}
+	if len(e.rawConns) > 0 {
+		e.rawConns[0].WriteToUDP(pkt, addr)
+	}
+}
+
+// streamUDPAddr traduce la dirección remota de una conexión a *net.UDPAddr,
+// que es lo que usa el resto del engine para cookies y endpoints.
+func streamUDPAddr(a net.Addr) *net.UDPAddr {
+	switch v := a.(type) {
+	case *net.TCPAddr:
+		return &net.UDPAddr{IP: v.IP, Port: v.Port, Zone: v.Zone}
+	case *net.UDPAddr:
+		return v
+	}
+	if host, port, err := net.SplitHostPort(a.String()); err == nil {
+		if ua, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, port)); err == nil {
+			return ua
+		}
+	}
+	return &net.UDPAddr{IP: net.IPv4zero}
+}
@@ -0,0 +1,74 @@
+package engine
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+	"golang.zx2c4.com/wireguard/tun"
+
+	"github.com/Soyunomas/taltun/pkg/netutil"
+)
+
+const tunCloneDevice = "/dev/net/tun"
+
+// createTUNQueues abre la TUN con n colas. Con una sola se usa tun.CreateTUN
+// tal cual; con más, todas (también la primera) deben abrirse con
+// IFF_MULTI_QUEUE, así que se abren a mano y se envuelven sin monitor de
+// eventos (el engine no los usa).
+func createTUNQueues(name string, mtu, n int) ([]tun.Device, error) {
+	if n <= 1 {
+		dev, err := tun.CreateTUN(name, mtu)
+		if err != nil {
+			return nil, err
+		}
+		return []tun.Device{dev}, nil
+	}
+
+	queues := make([]tun.Device, 0, n)
+	closeAll := func() {
+		for _, q := range queues {
+			q.Close()
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		dev, err := openTUNQueue(name)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("cola %d: %v", i, err)
+		}
+		queues = append(queues, dev)
+	}
+
+	if err := netutil.SetLinkMTU(name, mtu); err != nil {
+		closeAll()
+		return nil, err
+	}
+	return queues, nil
+}
+
+func openTUNQueue(name string) (tun.Device, error) {
+	fd, err := unix.Open(tunCloneDevice, unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	// Mismas flags que tun.CreateTUN (IFF_VNET_HDR: offloads) + multi-queue.
+	ifr.SetUint16(unix.IFF_TUN | unix.IFF_NO_PI | unix.IFF_VNET_HDR | unix.IFF_MULTI_QUEUE)
+	if err := unix.IoctlIfreq(fd, unix.TUNSETIFF, ifr); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("TUNSETIFF multi-queue: %v", err)
+	}
+
+	dev, _, err := tun.CreateUnmonitoredTUNFromFD(fd)
+	if err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return dev, nil
+}
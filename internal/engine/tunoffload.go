@@ -0,0 +1,81 @@
+package engine
+
+import (
+	"log"
+
+	"golang.zx2c4.com/wireguard/tun"
+
+	"github.com/Soyunomas/taltun/pkg/pool"
+)
+
+// --- TUN GSO / GRO (cabeceras virtio) ---
+//
+// La TUN se abre con IFF_VNET_HDR, así que el Kernel nos da super-paquetes TSO
+// de hasta 64 KB y acepta super-paquetes de vuelta:
+//
+//   - TX: tun.Device.Read lee el super-paquete en su propio buffer y lo trocea
+//     en segmentos del tamaño del MTU, uno por buffer del pool, antes de que los
+//     enrutemos y cifremos. Solo hay que darle buffers de sobra (tunReadBatch).
+//   - RX: tun.Device.Write junta (GRO) los segmentos TCP/UDP consecutivos de un
+//     mismo flujo, pero solo entre los paquetes de una misma llamada y dentro de
+//     la capacidad de cada buffer. Por eso cada worker RX acumula lo descifrado
+//     en un tunWriter con buffers de 64 KB y escribe una vez por lote.
+
+// tunReadBatch es el máximo de segmentos por lectura de la TUN
+// (conn.IdealBatchSize en wireguard-go): un super-paquete de 64 KB con MSS
+// pequeño no cabe en BatchSize buffers.
+const tunReadBatch = 128
+
+// tunGROBufferSize deja sitio para un super-paquete completo tras el headroom.
+const tunGROBufferSize = TunHeadroom + 65535
+
+// tunWriter acumula paquetes descifrados para una cola de la TUN. No es seguro
+// para uso concurrente: cada worker RX tiene el suyo.
+type tunWriter struct {
+	dev   tun.Device
+	store [][]byte // Buffers propios (capacidad para coalescer)
+	bufs  [][]byte // Vistas que se pasan a Write (Write puede reordenarlas)
+	n     int
+	debug bool
+}
+
+// newTunWriter devuelve nil si el dispositivo no hace GRO (sin cabecera virtio
+// escribe de uno en uno y acumular solo añadiría una copia).
+func newTunWriter(dev tun.Device, debug bool) *tunWriter {
+	if dev.BatchSize() <= 1 {
+		return nil
+	}
+	w := &tunWriter{
+		dev:   dev,
+		store: make([][]byte, BatchSize),
+		bufs:  make([][]byte, BatchSize),
+		debug: debug,
+	}
+	for i := range w.store {
+		w.store[i] = make([]byte, tunGROBufferSize)
+	}
+	return w
+}
+
+// add copia el paquete (en buff[TunHeadroom:]) al lote y devuelve buff al pool.
+func (w *tunWriter) add(plaintext []byte, buff *pool.Buff) {
+	n := copy(w.store[w.n][TunHeadroom:], plaintext)
+	w.bufs[w.n] = w.store[w.n][:TunHeadroom+n]
+	w.n++
+	pool.Put(buff)
+
+	if w.n == len(w.store) {
+		w.flush()
+	}
+}
+
+// flush escribe el lote acumulado en la TUN.
+func (w *tunWriter) flush() {
+	if w.n == 0 {
+		return
+	}
+	if _, err := w.dev.Write(w.bufs[:w.n], TunHeadroom); err != nil && w.debug {
+		log.Printf("❌ TUN Write Error: %v", err)
+	}
+	w.n = 0
+}
@@ -0,0 +1,173 @@
+package engine
+
+import (
+	"crypto/cipher"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Soyunomas/taltun/pkg/pool"
+	"github.com/Soyunomas/taltun/pkg/protocol"
+	"github.com/Soyunomas/taltun/pkg/qos"
+)
+
+// --- CIFRADO PARALELO (TUN -> WORKERS -> SECUENCIADOR -> UDP) ---
+//
+// Como en wireguard-go: el lector de la TUN solo enruta y asigna nonces (barato
+// y secuencial); el Seal de cada lote lo hace cualquiera de los workers. Cada
+// lote va a la vez a encCh (trabajo) y a seqCh (orden): el secuenciador espera
+// a que el lote que toca esté cifrado antes de pasarlo a loopUdpBatchWrite, así
+// que los paquetes salen en el mismo orden en que se leyeron de la TUN (y con
+// ellos cada flujo) aunque se cifren en paralelo.
+
+// txPipelineDepth es el máximo de lotes en vuelo entre la TUN y el socket.
+// Con la cola llena el lector se bloquea: la TUN absorbe la presión.
+const txPipelineDepth = 128
+
+// txPacket es un paquete en el pipeline. El buffer es el mismo en el que se
+// leyó de la TUN (con HeaderSize libre delante): se cifra en el sitio.
+type txPacket struct {
+	buff     *pool.Buff
+	size     int // Longitud del texto plano
+	peer     *PeerInfo
+	aead     cipher.AEAD
+	endpoint *net.UDPAddr
+	nonce    [protocol.NonceSize]byte
+	fragSize int // > 0: no cabe en el camino, sale en trozos de este tamaño
+	class    qos.Class
+	seq      uint64 // Contador del nonce (para el QoS)
+
+	wire  []byte      // Resultado (nil = descartar)
+	frags []txRequest // Resultado troceado (ver fragment.go)
+}
+
+// txJob es un lote leído de la TUN de una vez. done se señaliza cuando todos
+// sus paquetes están cifrados.
+type txJob struct {
+	pkts [tunReadBatch]txPacket
+	n    int
+	done chan struct{}
+}
+
+var txJobPool = sync.Pool{
+	New: func() interface{} {
+		return &txJob{done: make(chan struct{}, 1)}
+	},
+}
+
+// txPipeline conecta el lector de la TUN con los workers y el secuenciador.
+type txPipeline struct {
+	encCh chan *txJob
+	seqCh chan *txJob
+	wg    sync.WaitGroup
+}
+
+// startTxPipeline arranca los workers de cifrado (uno por core) y el
+// secuenciador. stop los detiene tras vaciar lo que quede en vuelo.
+func (e *Engine) startTxPipeline() *txPipeline {
+	tp := &txPipeline{
+		encCh: make(chan *txJob, txPipelineDepth),
+		seqCh: make(chan *txJob, txPipelineDepth),
+	}
+	for i := 0; i < runtime.NumCPU(); i++ {
+		go e.encryptWorker(tp.encCh)
+	}
+	tp.wg.Add(1)
+	go func() {
+		defer tp.wg.Done()
+		e.txSequencer(tp.seqCh)
+	}()
+	return tp
+}
+
+// submit entrega un lote al pipeline (primero a la cola de orden: el
+// secuenciador nunca espera un lote que no haya visto).
+func (tp *txPipeline) submit(job *txJob) {
+	tp.seqCh <- job
+	tp.encCh <- job
+}
+
+func (tp *txPipeline) stop() {
+	close(tp.encCh)
+	close(tp.seqCh)
+	tp.wg.Wait()
+}
+
+func (e *Engine) encryptWorker(encCh <-chan *txJob) {
+	for job := range encCh {
+		for i := 0; i < job.n; i++ {
+			e.encryptPacket(&job.pkts[i])
+		}
+		job.done <- struct{}{}
+	}
+}
+
+func (e *Engine) encryptPacket(p *txPacket) {
+	offset := protocol.HeaderSize
+	buf := p.buff.B
+
+	protocol.EncodeDataHeader(buf[:offset], e.localVIP, p.nonce[:])
+	encrypted := p.aead.Seal(buf[offset:offset], p.nonce[:], buf[offset:offset+p.size], nil)
+	atomic.AddUint64(&p.peer.BytesTx, uint64(len(encrypted)))
+
+	if p.fragSize > 0 {
+		p.frags = e.fragment(buf[:offset+len(encrypted)], p.fragSize, p.endpoint)
+		return
+	}
+	p.wire = e.wrap(buf[:offset+len(encrypted)])
+}
+
+// txSequencer recoge los lotes en el orden de lectura y los agrupa hacia el
+// socket UDP. Vacía el lote en curso cuando no hay más trabajo esperando, para
+// no retener paquetes a cambio de agrupar más.
+func (e *Engine) txSequencer(seqCh <-chan *txJob) {
+	batch := txBatchPool.Get().(*TxBatch)
+	batch.Len = 0
+
+	push := func(req txRequest) {
+		batch.Reqs[batch.Len] = req
+		batch.Len++
+		if batch.Len == BatchSize {
+			e.sendBatchSafe(batch)
+			batch = txBatchPool.Get().(*TxBatch)
+			batch.Len = 0
+		}
+	}
+
+	for job := range seqCh {
+		<-job.done
+
+		for i := 0; i < job.n; i++ {
+			p := &job.pkts[i]
+			if p.frags != nil {
+				// Solo peers UDP (ver fragmentSize).
+				for _, req := range p.frags {
+					req.VIP, req.Class, req.Seq = p.peer.VirtualIP, p.class, p.seq
+					push(req)
+				}
+				pool.Put(p.buff)
+			} else if p.wire == nil {
+				pool.Put(p.buff)
+			} else if stream := p.peer.GetStream(); stream != nil || p.peer.UsesStream() {
+				// Transportes de flujo: fuera del lote UDP (la conexión copia y encola).
+				if stream != nil {
+					stream.WritePacket(p.wire)
+				}
+				pool.Put(p.buff)
+			} else {
+				push(txRequest{Data: p.wire, Buff: p.buff, Addr: p.endpoint, VIP: p.peer.VirtualIP, Class: p.class, Seq: p.seq})
+			}
+			*p = txPacket{}
+		}
+		job.n = 0
+		txJobPool.Put(job)
+
+		if batch.Len > 0 && len(seqCh) == 0 {
+			e.sendBatchSafe(batch)
+			batch = txBatchPool.Get().(*TxBatch)
+			batch.Len = 0
+		}
+	}
+	txBatchPool.Put(batch)
+}
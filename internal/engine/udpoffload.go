@@ -0,0 +1,174 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/sys/unix"
+
+	"github.com/Soyunomas/taltun/pkg/netutil"
+	"github.com/Soyunomas/taltun/pkg/pool"
+)
+
+// --- UDP GSO / GRO ---
+//
+// TX: loopUdpBatchWrite agrupa los paquetes consecutivos de un lote que van al
+// mismo destino con el mismo tamaño (el último puede ser menor) en un solo
+// mensaje con UDP_SEGMENT. RX: con UDP_GRO cada lectura puede traer varios
+// datagramas concatenados; se trocean aquí y cada uno sigue el camino normal.
+// Si el Kernel no lo admite (o un envío GSO falla con EIO) se vuelve a un
+// datagrama por mensaje.
+
+// groBatchSize: con GRO cada buffer de lectura es de 64 KB, así que se leen
+// menos mensajes por llamada (cada uno suele traer decenas de datagramas).
+const groBatchSize = 16
+
+// gsoWriter conserva entre lotes la memoria del agrupado GSO.
+type gsoWriter struct {
+	arena []byte   // Super-buffers (un lote nunca ocupa más de BatchSize buffers)
+	oobs  [][]byte // cmsg UDP_SEGMENT por mensaje
+}
+
+func newGSOWriter() *gsoWriter {
+	w := &gsoWriter{
+		arena: make([]byte, BatchSize*pool.Size()),
+		oobs:  make([][]byte, BatchSize),
+	}
+	for i := range w.oobs {
+		w.oobs[i] = make([]byte, netutil.GSOControlSize)
+	}
+	return w
+}
+
+// coalesce rellena msgs con los paquetes del lote y devuelve cuántos mensajes
+// hay que enviar. Un mensaje de un solo paquete apunta a su buffer original;
+// solo los agrupados se copian a la arena.
+func (w *gsoWriter) coalesce(reqs []txRequest, msgs []ipv4.Message) int {
+	nMsgs := 0
+	arenaOff := 0
+
+	var (
+		cur      *ipv4.Message
+		curAddr  *net.UDPAddr
+		curStart int // Inicio en la arena (si ya está agrupado)
+		segSize  int
+		segs     int
+		curLen   int
+		closed   bool // El último segmento fue menor: no admite más
+	)
+
+	for i := range reqs {
+		data := reqs[i].Data
+		addr := reqs[i].Addr
+
+		if cur != nil && !closed && len(data) <= segSize && segs < netutil.UDPMaxSegments &&
+			curLen+len(data) <= netutil.UDPMaxGSOSize && sameUDPAddr(curAddr, addr) {
+			if segs == 1 {
+				curStart = arenaOff
+				arenaOff += copy(w.arena[arenaOff:], cur.Buffers[0])
+			}
+			arenaOff += copy(w.arena[arenaOff:], data)
+			curLen += len(data)
+			segs++
+			closed = len(data) < segSize
+			cur.Buffers[0] = w.arena[curStart:arenaOff]
+			cur.OOB = netutil.PutGSOSize(w.oobs[nMsgs-1], uint16(segSize))
+			continue
+		}
+
+		cur = &msgs[nMsgs]
+		nMsgs++
+		cur.Buffers[0] = data
+		cur.Addr = addr
+		cur.OOB = nil
+		curAddr = addr
+		segSize = len(data)
+		segs = 1
+		curLen = len(data)
+		closed = false
+	}
+	return nMsgs
+}
+
+func sameUDPAddr(a, b *net.UDPAddr) bool {
+	return a == b || (a.Port == b.Port && a.IP.Equal(b.IP))
+}
+
+// writeBatchGSO envía un lote agrupando con GSO. Si el Kernel rechaza el
+// agrupado se reenvía datagrama a datagrama: EIO (la interfaz de salida no
+// admite el offload) lo desactiva para siempre; EINVAL (p.ej. un segmento
+// mayor que el MTU de la ruta) solo afecta a este lote.
+func (e *Engine) writeBatchGSO(conn *ipv4.PacketConn, w *gsoWriter, reqs []txRequest, msgs []ipv4.Message) (int, error) {
+	nMsgs := w.coalesce(reqs, msgs)
+	if nMsgs == len(reqs) {
+		return conn.WriteBatch(msgs[:nMsgs], 0)
+	}
+
+	n, err := conn.WriteBatch(msgs[:nMsgs], 0)
+	if err == nil || n > 0 || !(errors.Is(err, unix.EIO) || errors.Is(err, unix.EINVAL)) {
+		return n, err
+	}
+	if errors.Is(err, unix.EIO) {
+		e.udpGSO.Store(false)
+		log.Printf("⚠️ UDP GSO rechazado por el Kernel (%v): desactivado", err)
+	}
+	for i := range reqs {
+		msgs[i].Buffers[0] = reqs[i].Data
+		msgs[i].Addr = reqs[i].Addr
+		msgs[i].OOB = nil
+	}
+	return conn.WriteBatch(msgs[:len(reqs)], 0)
+}
+
+// loopUdpGROToTun es loopUdpBatchToTun con UDP_GRO activo en el socket.
+func (e *Engine) loopUdpGROToTun(conn *ipv4.PacketConn, sockIdx int) error {
+	log.Printf("⚡ Batch RX Worker #%d iniciado (GRO)", sockIdx)
+
+	msgs := make([]ipv4.Message, groBatchSize)
+	for i := range msgs {
+		msgs[i].Buffers = [][]byte{make([]byte, netutil.GROBufferSize)}
+		msgs[i].OOB = make([]byte, netutil.GROControlSize)
+	}
+
+	var lastVIP uint32
+	var lastPeer *PeerInfo
+	tw := newTunWriter(e.tunQueue(sockIdx), e.cfg.Debug)
+
+	for {
+		nMsgs, err := conn.ReadBatch(msgs, 0)
+		if err != nil {
+			if e.closed.Load() || strings.Contains(err.Error(), "closed network connection") {
+				return nil
+			}
+			return fmt.Errorf("readbatch error: %v", err)
+		}
+
+		for i := 0; i < nMsgs; i++ {
+			msg := &msgs[i]
+			data := msg.Buffers[0][:msg.N]
+			rAddr := msg.Addr.(*net.UDPAddr)
+
+			segSize := netutil.GROSize(msg.OOB[:msg.NN])
+			if segSize <= 0 {
+				segSize = len(data)
+			}
+
+			for off := 0; off < len(data); off += segSize {
+				seg := data[off:min(off+segSize, len(data))]
+				if len(seg) > pool.Size() {
+					continue // Nunca generamos algo así: no es nuestro
+				}
+				buff := pool.Get()
+				n := copy(buff.B, seg)
+				e.processOnePacket(buff.B[:n], buff, rAddr, sockIdx, nil, tw, &lastVIP, &lastPeer)
+			}
+		}
+		if tw != nil {
+			tw.flush()
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package engine
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"golang.org/x/net/ipv4"
+
+	"github.com/Soyunomas/taltun/pkg/netutil"
+)
+
+func TestGSOCoalesce(t *testing.T) {
+	a := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 9000}
+	aCopy := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 9000}
+	b := &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 9000}
+
+	pkt := func(size int, fill byte) []byte { return bytes.Repeat([]byte{fill}, size) }
+	reqs := []txRequest{
+		{Data: pkt(100, 1), Addr: a},
+		{Data: pkt(100, 2), Addr: aCopy}, // Mismo destino, otro puntero
+		{Data: pkt(60, 3), Addr: a},      // Último segmento menor: cierra el grupo
+		{Data: pkt(60, 4), Addr: a},      // No cabe tras uno menor
+		{Data: pkt(100, 5), Addr: b},     // Otro destino
+		{Data: pkt(120, 6), Addr: b},     // Mayor que el segmento: mensaje nuevo
+	}
+
+	msgs := make([]ipv4.Message, BatchSize)
+	for i := range msgs {
+		msgs[i].Buffers = make([][]byte, 1)
+	}
+	w := newGSOWriter()
+	n := w.coalesce(reqs, msgs)
+
+	if n != 4 {
+		t.Fatalf("Expected 4 messages, got %d", n)
+	}
+
+	want := append(append(pkt(100, 1), pkt(100, 2)...), pkt(60, 3)...)
+	if !bytes.Equal(msgs[0].Buffers[0], want) {
+		t.Errorf("First message not coalesced correctly (len %d)", len(msgs[0].Buffers[0]))
+	}
+	if len(msgs[0].OOB) != netutil.GSOControlSize {
+		t.Errorf("Coalesced message without UDP_SEGMENT cmsg")
+	}
+
+	// Los mensajes de un solo paquete van sin cmsg y sin copia
+	for i, want := range []*txRequest{&reqs[3], &reqs[4], &reqs[5]} {
+		m := msgs[i+1]
+		if m.OOB != nil {
+			t.Errorf("Message %d: unexpected cmsg", i+1)
+		}
+		if &m.Buffers[0][0] != &want.Data[0] {
+			t.Errorf("Message %d: single packet was copied", i+1)
+		}
+	}
+}
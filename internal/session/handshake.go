@@ -0,0 +1,180 @@
+package session
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Soyunomas/taltun/pkg/crypto"
+)
+
+// HandshakeTimeout es lo que se reutiliza el Init pendiente (su random y su
+// clave ML-KEM) en reintentos y cookie replies antes de generar otro.
+const HandshakeTimeout = 5 * time.Second
+
+// RandomSize es el tamaño del random de cada handshake v2+.
+const RandomSize = 16
+
+var (
+	ErrNoPendingInit = errors.New("no hay handshake pendiente")
+	ErrDuplicateResp = errors.New("respuesta duplicada")
+)
+
+// handshakeState guarda el estado efímero de los handshakes v2+ con un peer.
+//
+// Cada handshake lleva un random por lado (y, si es híbrido, una clave ML-KEM
+// nueva) que entra en el KDF, así que cada uno produce una clave distinta y
+// ambos lados deben ponerse de acuerdo sobre cuál usar: los reintentos
+// reutilizan el mismo Init (iniciador) y el mismo Resp (responder), y los
+// duplicados se detectan y se ignoran.
+type handshakeState struct {
+	mu sync.Mutex
+
+	// Lado iniciador
+	initRandom     [RandomSize]byte
+	local          *crypto.KEMKeyPair
+	localPub       []byte
+	localTime      time.Time
+	pending        bool
+	lastResp       [sha256.Size]byte
+	done           bool
+	initTranscript []byte // Extensión de nuestro último Init
+
+	// Lado responder: último Resp, por si el Init llega repetido
+	remoteInit   [sha256.Size]byte
+	respRandom   [RandomSize]byte
+	remoteSecret []byte
+	remoteCT     []byte
+	responded    bool
+}
+
+// InitiateHandshake devuelve el random y, si hybrid, la clave de
+// encapsulación a enviar en un Init. Genera unos nuevos salvo que haya un
+// Init reciente sin completar.
+func (p *Peer) InitiateHandshake(hybrid bool) (random [RandomSize]byte, kemPub []byte, err error) {
+	h := &p.hs
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.pending && !h.done && time.Since(h.localTime) < HandshakeTimeout && hybrid == (h.local != nil) {
+		return h.initRandom, h.localPub, nil
+	}
+	if _, err := rand.Read(random[:]); err != nil {
+		return random, nil, err
+	}
+	var kp *crypto.KEMKeyPair
+	if hybrid {
+		if kp, err = crypto.GenerateKEMKeyPair(); err != nil {
+			return random, nil, err
+		}
+		kemPub = kp.PublicBytes()
+	}
+	h.initRandom = random
+	h.local = kp
+	h.localPub = kemPub
+	h.localTime = time.Now()
+	h.pending = true
+	h.done = false
+	return random, kemPub, nil
+}
+
+// InitPending indica si tenemos un Init en vuelo.
+func (p *Peer) InitPending() bool {
+	h := &p.hs
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.pending && !h.done && time.Since(h.localTime) < HandshakeTimeout
+}
+
+// AbandonInit descarta el Init en vuelo (el peer atiende otro handshake).
+func (p *Peer) AbandonInit() {
+	h := &p.hs
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.pending = false
+	h.local = nil
+	h.localPub = nil
+}
+
+// CompleteInit acepta el Resp (su random y, si es híbrido, el ciphertext)
+// de nuestro Init y devuelve el secreto ML-KEM (nil si es clásico, aunque
+// nuestro Init fuera híbrido). Un Resp
+// repetido devuelve ErrDuplicateResp: la clave de sesión no cambia.
+func (p *Peer) CompleteInit(random [RandomSize]byte, ciphertext []byte) ([]byte, error) {
+	h := &p.hs
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.pending {
+		return nil, ErrNoPendingInit
+	}
+	sum := sha256.Sum256(append(random[:], ciphertext...))
+	if h.done && sum == h.lastResp {
+		return nil, ErrDuplicateResp
+	}
+	var secret []byte
+	if h.local != nil && len(ciphertext) > 0 {
+		var err error
+		if secret, err = h.local.Decapsulate(ciphertext); err != nil {
+			return nil, err
+		}
+	}
+	h.lastResp = sum
+	h.done = true
+	return secret, nil
+}
+
+// RespondHandshake prepara el Resp a un Init (su random y su clave ML-KEM, si
+// la trae): random del Resp y, si es híbrido, secreto y ciphertext. Si el Init
+// es el mismo que el anterior devuelve el Resp ya hecho y dup=true: hay que
+// reenviarlo, pero la clave de sesión no cambia.
+func (p *Peer) RespondHandshake(initRandom [RandomSize]byte, kemPub []byte) (random [RandomSize]byte, secret, ciphertext []byte, dup bool, err error) {
+	h := &p.hs
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sum := sha256.Sum256(append(initRandom[:], kemPub...))
+	if h.responded && sum == h.remoteInit {
+		return h.respRandom, h.remoteSecret, h.remoteCT, true, nil
+	}
+	if _, err = rand.Read(random[:]); err != nil {
+		return random, nil, nil, false, err
+	}
+	if len(kemPub) > 0 {
+		if secret, ciphertext, err = crypto.KEMEncapsulate(kemPub); err != nil {
+			return random, nil, nil, false, err
+		}
+	}
+	h.remoteInit = sum
+	h.respRandom = random
+	h.remoteSecret = secret
+	h.remoteCT = ciphertext
+	h.responded = true
+	return random, secret, ciphertext, false, nil
+}
+
+// SetInitTranscript recuerda la extensión del Init que enviamos: el KDF del Resp
+// la necesita.
+func (p *Peer) SetInitTranscript(t []byte) {
+	h := &p.hs
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.initTranscript = t
+}
+
+// InitTranscript devuelve la extensión de nuestro último Init (nil si no hubo).
+func (p *Peer) InitTranscript() []byte {
+	h := &p.hs
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.initTranscript
+}
+
+// HandshakeWins decide quién responde cuando ambos lados inician a la vez: el
+// de clave pública estática mayor mantiene su Init y descarta el del otro.
+func HandshakeWins(localPub, remotePub []byte) bool {
+	return bytes.Compare(localPub, remotePub) > 0
+}
@@ -1,14 +1,19 @@
 package session
 
 import (
+	"bytes"
 	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
 	"errors"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 	"golang.org/x/sys/cpu"
 	
 	"github.com/Soyunomas/taltun/pkg/replay"
+	"github.com/Soyunomas/taltun/pkg/transport"
 )
 
 // CacheLineSize se usa para evitar False Sharing.
@@ -27,22 +32,69 @@ type Peer struct {
 	
 	// Crypto State (Protegido por RWMutex propio)
 	cryptoMu  sync.RWMutex 
-	aead      cipher.AEAD      // Clave actual
-	prevAEAD  cipher.AEAD      // Clave anterior (para transición suave)
+	sendAEAD  cipher.AEAD      // Clave de envío actual
+	aead      cipher.AEAD      // Clave de recepción actual
+	prevAEAD  cipher.AEAD      // Clave de recepción anterior (para transición suave)
 	
 	LastHandshake    time.Time
 	HandshakePending bool
 
+	// Versión y features negociadas en el último handshake
+	protoVersion  uint8
+	protoFeatures uint16
+
+	// Hay configuración (DNS) pendiente de enviar tras el último handshake
+	configPending atomic.Bool
+
+	// Estado efímero de los handshakes v2+: randoms y ML-KEM (ver handshake.go)
+	hs handshakeState
+
+	// Búsqueda del MTU del camino (ver pmtu.go)
+	pmtu pmtuState
+
+	// Serializa el procesado de handshakes de este peer entre los workers
+	handshakeMu sync.Mutex
+	// Timestamp (TAI64N) del último handshake v2+ aceptado del peer, Init o
+	// Resp. Protegido por handshakeMu.
+	lastTimestamp [12]byte
+
 	// Estado para DoS Protection (Cookie)
 	cookieMu    sync.Mutex
 	LastCookie  []byte    
 	CookieTime  time.Time 
+	// mac1 del último handshake v2+ enviado: los Cookie Reply van cifrados
+	// con él como dato adicional (nil = no esperamos ninguno).
+	lastMAC1     []byte
+	lastMAC1Time time.Time
 
 	_ [cacheLineSize]byte
 
 	// --- BLOQUE 2: Hot Control Data (Endpoint & Security) ---
 	endpointMu sync.RWMutex
 	endpoint   *net.UDPAddr
+
+	// Transporte configurado ("" o "udp" = UDP nativo) y conexión activa si el
+	// peer habla por un transporte de flujo (TCP...). Lock-free: se lee por paquete.
+	Transport string
+	stream    atomic.Pointer[streamRef]
+
+	// Hub: lo configuramos con endpoint (nos conectamos a él). Solo un hub
+	// puede anunciarnos su DNS.
+	Hub bool
+
+	// Destino y opciones para marcar la conexión de flujo. DialAddr conserva el
+	// hostname del endpoint (SNI, Host y proxies lo necesitan sin resolver).
+	DialAddr    string
+	DialOptions transport.Options
+
+	// Clave pre-compartida que se mezcla en el KDF de sesión (nil = sin PSK).
+	PresharedKey *[32]byte
+
+	// Clave pública estática del peer: configurada (KeyPinned) o aprendida del
+	// primer handshake válido (solo con min_protocol_version = 1). En ambos
+	// casos no cambia y solo se acepta esa. Hace falta para su mac1.
+	staticKey atomic.Pointer[[32]byte]
+	KeyPinned bool
 	
 	// Timestamps para Housekeeping (Keepalives)
 	// Se acceden frecuentemente, los protegemos o usamos atomics si fuera necesario estricto.
@@ -57,12 +109,20 @@ type Peer struct {
 
 	// --- BLOQUE 3: Atomic Counters (Hot Writes) ---
 	BytesTx uint64
+
+	// Contador de los nonces hacia este peer (ver NextNonce)
+	txNonce atomic.Uint64
 	
 	_ [cacheLineSize]byte
 
 	BytesRx uint64
 }
 
+// streamRef envuelve la interfaz para poder guardarla en un atomic.Pointer.
+type streamRef struct {
+	conn transport.Conn
+}
+
 func NewPeer(vip uint32, endpoint *net.UDPAddr) *Peer {
 	return &Peer{
 		VirtualIP:    vip,
@@ -85,6 +145,39 @@ func (p *Peer) SetEndpoint(addr *net.UDPAddr) {
 	p.endpoint = addr
 }
 
+// UsesStream indica si el peer está configurado para hablar por un transporte
+// de flujo en lugar de UDP (en ese caso nunca caemos a UDP).
+func (p *Peer) UsesStream() bool {
+	return p.Transport != "" && p.Transport != transport.UDP
+}
+
+// GetStream devuelve la conexión de flujo activa, o nil si el peer va por UDP.
+func (p *Peer) GetStream() transport.Conn {
+	if ref := p.stream.Load(); ref != nil {
+		return ref.conn
+	}
+	return nil
+}
+
+// SetStream fija la conexión de flujo por la que responder al peer
+// (nil = volver a UDP).
+func (p *Peer) SetStream(c transport.Conn) {
+	if c == nil {
+		p.stream.Store(nil)
+		return
+	}
+	p.stream.Store(&streamRef{conn: c})
+}
+
+// ClearStream olvida la conexión solo si sigue siendo c (otra más nueva pudo
+// reemplazarla mientras tanto).
+func (p *Peer) ClearStream(c transport.Conn) {
+	ref := p.stream.Load()
+	if ref != nil && ref.conn == c {
+		p.stream.CompareAndSwap(ref, nil)
+	}
+}
+
 // UpdateTimestamps actualiza los contadores de actividad.
 // isRx=true (Recibido), isRx=false (Enviado)
 func (p *Peer) UpdateTimestamps(isRx bool) {
@@ -126,11 +219,11 @@ func (p *Peer) MarkHandshakePending() {
 	p.cryptoMu.Unlock()
 }
 
-// GetAEAD devuelve el cifrador actual.
+// GetAEAD devuelve el cifrador de envío actual.
 func (p *Peer) GetAEAD() cipher.AEAD {
 	p.cryptoMu.RLock()
 	defer p.cryptoMu.RUnlock()
-	return p.aead
+	return p.sendAEAD
 }
 
 // Open intenta descifrar usando la clave actual, y si falla, la anterior.
@@ -163,8 +256,23 @@ func (p *Peer) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, erro
 	return nil, err
 }
 
-// SetSessionKey actualiza el cifrador y rota el anterior.
-func (p *Peer) SetSessionKey(newAead cipher.AEAD) {
+// noncePrefix son los 4 primeros bytes de nuestros nonces.
+var noncePrefix = [4]byte{0xCA, 0xFE, 0xBA, 0xBE}
+
+// NextNonce escribe en dst el siguiente nonce hacia el peer (prefijo fijo +
+// contador) y devuelve el contador. Cada peer lleva el suyo: con uno global,
+// el tráfico hacia otros peers separaría los contadores de sus paquetes y uno
+// retenido por el QoS caería antes fuera de su ventana anti-replay.
+func (p *Peer) NextNonce(dst []byte) uint64 {
+	ctr := p.txNonce.Add(1)
+	copy(dst[0:4], noncePrefix[:])
+	binary.BigEndian.PutUint64(dst[4:], ctr)
+	return ctr
+}
+
+// SetSessionKey actualiza los cifradores y rota el de recepción anterior.
+// send y recv son el mismo con nodos legacy (una sola clave por sesión).
+func (p *Peer) SetSessionKey(send, recv cipher.AEAD) {
 	p.cryptoMu.Lock()
 	defer p.cryptoMu.Unlock()
 	
@@ -173,11 +281,68 @@ func (p *Peer) SetSessionKey(newAead cipher.AEAD) {
 		p.prevAEAD = p.aead
 	}
 	
-	p.aead = newAead
+	p.sendAEAD = send
+	p.aead = recv
 	p.LastHandshake = time.Now()
 	p.HandshakePending = false
 }
 
+// PinStaticKey fija la clave pública configurada para el peer.
+func (p *Peer) PinStaticKey(pub [32]byte) {
+	p.staticKey.Store(&pub)
+	p.KeyPinned = true
+}
+
+// RemoteStatic devuelve la clave pública del peer (nil si aún no la conocemos).
+func (p *Peer) RemoteStatic() *[32]byte {
+	return p.staticKey.Load()
+}
+
+// AcceptStatic comprueba la clave pública de un handshake: si ya la conocemos
+// (configurada o aprendida) solo acepta esa. Una clave nueva no se recuerda
+// aquí sino con LearnStatic, cuando el handshake ha sido aceptado.
+func (p *Peer) AcceptStatic(pub []byte) bool {
+	if len(pub) != 32 {
+		return false
+	}
+	known := p.staticKey.Load()
+	return known == nil || subtle.ConstantTimeCompare(known[:], pub) == 1
+}
+
+// LearnStatic recuerda la clave pública de un peer sin clave configurada tras
+// su primer handshake aceptado, para poder iniciarle handshakes después. Una
+// vez aprendida ya no cambia: otro nodo no puede suplantarlo con su clave.
+func (p *Peer) LearnStatic(pub []byte) {
+	var k [32]byte
+	copy(k[:], pub)
+	p.staticKey.CompareAndSwap(nil, &k)
+}
+
+// SetProtocol guarda la versión y las features negociadas con el peer.
+func (p *Peer) SetProtocol(version uint8, features uint16) {
+	p.cryptoMu.Lock()
+	defer p.cryptoMu.Unlock()
+	p.protoVersion = version
+	p.protoFeatures = features
+}
+
+// Protocol devuelve la versión y las features negociadas (0 si aún no hubo handshake).
+func (p *Peer) Protocol() (version uint8, features uint16) {
+	p.cryptoMu.RLock()
+	defer p.cryptoMu.RUnlock()
+	return p.protoVersion, p.protoFeatures
+}
+
+// MarkConfigPending programa el envío de la configuración anunciada (DNS).
+func (p *Peer) MarkConfigPending() {
+	p.configPending.Store(true)
+}
+
+// TakeConfigPending devuelve true una sola vez por cada MarkConfigPending.
+func (p *Peer) TakeConfigPending() bool {
+	return p.configPending.Load() && p.configPending.CompareAndSwap(true, false)
+}
+
 func (p *Peer) ValidateReplay(counter uint64) bool {
 	return p.replayFilter.ValidateAndUpdate(counter)
 }
@@ -205,4 +370,51 @@ func (p *Peer) GetCookie() []byte {
 	return p.LastCookie
 }
 
+// LockHandshake reserva el peer para procesar un handshake: con varios
+// workers, dos mensajes del mismo peer no deben pisarse en SetSessionKey ni en
+// el estado del handshake. Liberar con UnlockHandshake.
+func (p *Peer) LockHandshake() {
+	p.handshakeMu.Lock()
+}
+
+func (p *Peer) UnlockHandshake() {
+	p.handshakeMu.Unlock()
+}
+
+// FreshTimestamp indica si ts es posterior al del último handshake aceptado
+// del peer: lo demás es un replay. Requiere LockHandshake.
+func (p *Peer) FreshTimestamp(ts [12]byte) bool {
+	return bytes.Compare(ts[:], p.lastTimestamp[:]) > 0
+}
+
+// AcceptTimestamp registra el timestamp de un handshake aceptado. Requiere
+// LockHandshake.
+func (p *Peer) AcceptTimestamp(ts [12]byte) {
+	p.lastTimestamp = ts
+}
+
+// SetLastMAC1 recuerda el mac1 del handshake que acabamos de enviar.
+func (p *Peer) SetLastMAC1(mac1 []byte) {
+	p.cookieMu.Lock()
+	defer p.cookieMu.Unlock()
+	p.lastMAC1 = append(p.lastMAC1[:0], mac1...)
+	p.lastMAC1Time = time.Now()
+}
+
+// TakeLastMAC1 aplica open con el mac1 pendiente y, si acepta, lo consume:
+// cada handshake admite un único Cookie Reply (un replay no sirve de nada).
+func (p *Peer) TakeLastMAC1(open func(mac1 []byte) bool) bool {
+	p.cookieMu.Lock()
+	defer p.cookieMu.Unlock()
+
+	if len(p.lastMAC1) == 0 || time.Since(p.lastMAC1Time) > HandshakeTimeout {
+		return false
+	}
+	if !open(p.lastMAC1) {
+		return false
+	}
+	p.lastMAC1 = p.lastMAC1[:0]
+	return true
+}
+
 var _ = cpu.CacheLinePad{}
@@ -0,0 +1,126 @@
+package session
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Tiempos de la búsqueda de PMTU
+const (
+	PMTUProbeTimeout = 1 * time.Second  // Sin respuesta en este tiempo, la sonda se da por perdida
+	PMTUProbeTries   = 2                // Intentos por tamaño antes de considerarlo demasiado grande
+	PMTURefresh      = 10 * time.Minute // Cada cuánto se vuelve a medir (la ruta puede cambiar)
+)
+
+// pmtuState es la búsqueda del MTU interno hacia un peer (estilo PLPMTUD,
+// RFC 8899): sondas cifradas del tamaño a medir; si llega la respuesta, el
+// tamaño cabe. Primero se prueba el máximo (en la mayoría de rutas cabe a la
+// primera) y si no, búsqueda binaria entre el mínimo y el máximo.
+type pmtuState struct {
+	mtu atomic.Int32 // MTU interno efectivo (0 = sin medir: se usa el configurado)
+
+	mu        sync.Mutex
+	searching bool
+	lo, hi    int  // lo: mayor tamaño que cabe (o el mínimo supuesto); hi: cota superior
+	acked     bool // Alguna sonda de esta búsqueda tuvo respuesta
+	seq       uint32
+	probeID   uint32 // Sonda en vuelo (0 = ninguna)
+	probeSize int
+	probeSent time.Time
+	tries     int
+	next      time.Time // Próxima búsqueda
+}
+
+// PathMTU devuelve el MTU interno efectivo hacia el peer, o def si aún no se
+// ha medido (o el peer no responde a las sondas).
+func (p *Peer) PathMTU(def int) int {
+	if mtu := p.pmtu.mtu.Load(); mtu > 0 {
+		return min(int(mtu), def)
+	}
+	return def
+}
+
+// NextPMTUProbe decide si toca enviar una sonda y de qué tamaño (MTU interno
+// candidato entre minMTU y maxMTU). Con una sonda en vuelo solo vuelve a
+// enviar cuando ha expirado.
+func (p *Peer) NextPMTUProbe(now time.Time, minMTU, maxMTU int) (id uint32, size int, ok bool) {
+	s := &p.pmtu
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.searching {
+		if now.Before(s.next) {
+			return 0, 0, false
+		}
+		s.searching = true
+		s.lo, s.hi, s.acked = minMTU, maxMTU, false
+		s.probeSize, s.tries = maxMTU, 0
+	} else if s.probeID != 0 {
+		if now.Sub(s.probeSent) < PMTUProbeTimeout {
+			return 0, 0, false
+		}
+		if s.tries >= PMTUProbeTries {
+			// No cabe: bajamos la cota superior.
+			s.hi = s.probeSize - 1
+			if !s.pickNext(now) {
+				return 0, 0, false
+			}
+		}
+	}
+
+	s.seq++
+	if s.seq == 0 {
+		s.seq = 1
+	}
+	s.probeID = s.seq
+	s.probeSent = now
+	s.tries++
+	return s.probeID, s.probeSize, true
+}
+
+// AckPMTUProbe registra la respuesta a la sonda id. Devuelve false si no es
+// la sonda en vuelo (duplicada o de una búsqueda anterior).
+func (p *Peer) AckPMTUProbe(id uint32, now time.Time) bool {
+	s := &p.pmtu
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.searching || id == 0 || id != s.probeID {
+		return false
+	}
+	s.lo = s.probeSize
+	s.acked = true
+	s.pickNext(now)
+	return true
+}
+
+// RestartPMTU fuerza una nueva búsqueda (p.ej. el peer ha cambiado de
+// endpoint). Mientras tanto se sigue usando el último MTU medido.
+func (p *Peer) RestartPMTU() {
+	s := &p.pmtu
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.searching = false
+	s.probeID = 0
+	s.next = time.Time{}
+}
+
+// pickNext prepara la siguiente sonda, o cierra la búsqueda si ya no queda
+// intervalo (devuelve false). Requiere s.mu.
+func (s *pmtuState) pickNext(now time.Time) bool {
+	s.probeID, s.tries = 0, 0
+	if s.lo < s.hi {
+		s.probeSize = (s.lo + s.hi + 1) / 2
+		return true
+	}
+
+	s.searching = false
+	s.next = now.Add(PMTURefresh)
+	// Sin ninguna respuesta no sabemos nada (peer antiguo o caído): se
+	// mantiene lo que hubiera.
+	if s.acked {
+		s.mtu.Store(int32(s.lo))
+	}
+	return false
+}
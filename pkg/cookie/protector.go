@@ -69,6 +69,18 @@ func (p *Protector) ValidateCookie(ip net.IP, cookie []byte) bool {
 	return false
 }
 
+// ValidateMAC comprueba un MAC calculado con la cookie de la IP (mac2 del
+// handshake): verify recibe la cookie actual y, si falla, la anterior.
+func (p *Protector) ValidateMAC(ip net.IP, verify func(cookie []byte) bool) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if verify(p.mac(ip, p.currentSecret[:])) {
+		return true
+	}
+	return verify(p.mac(ip, p.prevSecret[:]))
+}
+
 func (p *Protector) mac(ip net.IP, key []byte) []byte {
 	mac := hmac.New(sha256.New, key)
 	mac.Write(ip) // IP debería ser IPv4 (4 bytes) o IPv6 (16 bytes) canonicalizada
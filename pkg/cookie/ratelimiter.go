@@ -0,0 +1,108 @@
+package cookie
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Límites por origen para los handshakes (mismos valores que WireGuard).
+const (
+	HandshakesPerSecond = 20
+	HandshakeBurst      = 5
+
+	// Máximo de orígenes seguidos a la vez. Con la tabla llena los orígenes
+	// nuevos se rechazan hasta la siguiente limpieza.
+	RateTableSize = 1 << 16
+
+	rateGCInterval = time.Second
+	ratePacketCost = int64(time.Second) / HandshakesPerSecond
+	rateMaxTokens  = ratePacketCost * HandshakeBurst
+)
+
+type rateEntry struct {
+	lastTime time.Time
+	tokens   int64 // En nanosegundos de "crédito"
+}
+
+// RateLimiter es un token bucket por IP de origen (IPv4 completa, prefijo /64
+// en IPv6: un atacante suele tener el /64 entero). Evita que un solo origen
+// llene la cola de handshakes y fuerce cookies a todos los demás.
+type RateLimiter struct {
+	mu     sync.Mutex
+	table  map[[16]byte]rateEntry
+	lastGC time.Time
+
+	now func() time.Time // Reloj inyectable para tests
+}
+
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{
+		table: make(map[[16]byte]rateEntry),
+		now:   time.Now,
+	}
+}
+
+// Allow consume un handshake del presupuesto de ip. Devuelve false si ese
+// origen va por encima del ritmo permitido.
+func (r *RateLimiter) Allow(ip net.IP) bool {
+	key, ok := rateKey(ip)
+	if !ok {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	if now.Sub(r.lastGC) >= rateGCInterval {
+		r.gc(now)
+	}
+
+	entry, found := r.table[key]
+	if !found {
+		if len(r.table) >= RateTableSize {
+			return false
+		}
+		r.table[key] = rateEntry{lastTime: now, tokens: rateMaxTokens - ratePacketCost}
+		return true
+	}
+
+	entry.tokens += now.Sub(entry.lastTime).Nanoseconds()
+	entry.lastTime = now
+	if entry.tokens > rateMaxTokens {
+		entry.tokens = rateMaxTokens
+	}
+
+	allowed := entry.tokens >= ratePacketCost
+	if allowed {
+		entry.tokens -= ratePacketCost
+	}
+	r.table[key] = entry
+	return allowed
+}
+
+// gc elimina los orígenes inactivos: tras rateGCInterval sin tráfico su cubo
+// ya estaría lleno, así que borrarlos equivale a no haberlos visto nunca.
+func (r *RateLimiter) gc(now time.Time) {
+	for key, entry := range r.table {
+		if now.Sub(entry.lastTime) > rateGCInterval {
+			delete(r.table, key)
+		}
+	}
+	r.lastGC = now
+}
+
+func rateKey(ip net.IP) ([16]byte, bool) {
+	var key [16]byte
+	if ip4 := ip.To4(); ip4 != nil {
+		copy(key[:4], ip4)
+		key[15] = 4 // Distinto de cualquier prefijo IPv6 con los mismos bytes
+		return key, true
+	}
+	if ip6 := ip.To16(); ip6 != nil {
+		copy(key[:8], ip6[:8])
+		return key, true
+	}
+	return key, false
+}
@@ -0,0 +1,57 @@
+package cookie
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	clock := time.Unix(1000, 0)
+	r := NewRateLimiter()
+	r.now = func() time.Time { return clock }
+
+	attacker := net.ParseIP("203.0.113.7")
+	for i := 0; i < HandshakeBurst; i++ {
+		if !r.Allow(attacker) {
+			t.Fatalf("Handshake %d within burst rejected", i)
+		}
+	}
+	if r.Allow(attacker) {
+		t.Fatalf("Handshake over burst accepted")
+	}
+
+	// Otro origen no se ve afectado
+	if !r.Allow(net.ParseIP("198.51.100.1")) {
+		t.Errorf("Independent source rejected")
+	}
+
+	// Recupera un token cada 1/HandshakesPerSecond
+	clock = clock.Add(time.Second / HandshakesPerSecond)
+	if !r.Allow(attacker) {
+		t.Errorf("Token not refilled")
+	}
+	if r.Allow(attacker) {
+		t.Errorf("Refilled more than one token")
+	}
+
+	// IPv6: todo el /64 comparte cubo
+	a := net.ParseIP("2001:db8:1:2::1")
+	b := net.ParseIP("2001:db8:1:2::ffff")
+	for i := 0; i < HandshakeBurst; i++ {
+		r.Allow(a)
+	}
+	if r.Allow(b) {
+		t.Errorf("Same /64 prefix not rate limited together")
+	}
+	if !r.Allow(net.ParseIP("2001:db8:1:3::1")) {
+		t.Errorf("Different /64 prefix rejected")
+	}
+
+	// La limpieza retira los orígenes inactivos
+	clock = clock.Add(2 * rateGCInterval)
+	r.Allow(attacker)
+	if len(r.table) != 1 {
+		t.Errorf("Expected 1 entry after GC, got %d", len(r.table))
+	}
+}
@@ -0,0 +1,157 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"sync/atomic"
+
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/sys/cpu"
+)
+
+// CipherSuite identifica el AEAD del dataplane. El valor viaja en el handshake.
+type CipherSuite uint8
+
+const (
+	// ChaCha20-Poly1305: obligatorio en todos los nodos (y el único de los legacy).
+	CipherChaCha20Poly1305 CipherSuite = 0
+	// AES-256-GCM: el más rápido con AES-NI / ARMv8 Crypto Extensions.
+	CipherAES256GCM CipherSuite = 1
+	// XChaCha20-Poly1305 con salt por sesión y el nonce de 12 bytes del protocolo (ver xchachaAEAD).
+	CipherXChaCha20Poly1305 CipherSuite = 2
+)
+
+var cipherNames = map[CipherSuite]string{
+	CipherChaCha20Poly1305:  "chacha20poly1305",
+	CipherAES256GCM:         "aes256gcm",
+	CipherXChaCha20Poly1305: "xchacha20poly1305",
+}
+
+func (s CipherSuite) String() string {
+	if name, ok := cipherNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("cipher(%d)", uint8(s))
+}
+
+// ParseCipherSuite traduce el nombre de configuración a su CipherSuite.
+func ParseCipherSuite(name string) (CipherSuite, error) {
+	for s, n := range cipherNames {
+		if n == name {
+			return s, nil
+		}
+	}
+	return 0, fmt.Errorf("cipher desconocido: %q (chacha20poly1305 | aes256gcm | xchacha20poly1305)", name)
+}
+
+// HasAESHardware indica si la CPU acelera AES-GCM. Sin ella ChaCha20 es más
+// rápido y, además, AES por software no es de tiempo constante.
+func HasAESHardware() bool {
+	return (cpu.X86.HasAES && cpu.X86.HasPCLMULQDQ) || (cpu.ARM64.HasAES && cpu.ARM64.HasPMULL) || cpu.S390X.HasAESGCM
+}
+
+// PreferredCipherSuite es la elección automática para esta máquina.
+func PreferredCipherSuite() CipherSuite {
+	if HasAESHardware() {
+		return CipherAES256GCM
+	}
+	return CipherChaCha20Poly1305
+}
+
+// NewAEAD construye el AEAD de una suite con una clave de 32 bytes.
+// Todos exponen un nonce de 12 bytes (NonceSize del protocolo).
+func NewAEAD(suite CipherSuite, key []byte) (cipher.AEAD, error) {
+	switch suite {
+	case CipherChaCha20Poly1305:
+		return chacha20poly1305.New(key)
+	case CipherAES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case CipherXChaCha20Poly1305:
+		return newXChaCha(key)
+	}
+	return nil, fmt.Errorf("cipher no soportado: %v", suite)
+}
+
+// xchachaAEAD es XChaCha20-Poly1305 sobre el nonce de 12 bytes del protocolo:
+// el nonce de 24 bytes es salt ‖ nonce, con un salt de 12 bytes derivado de la
+// clave de sesión. Los 16 bytes que entran en HChaCha20 (salt + los 4 primeros
+// del nonce) dependen así de la sesión en vez de ser una constante.
+//
+// x/crypto recalcula HChaCha20 en cada Seal/Open; aquí la subclave se guarda
+// por prefijo del nonce, que en la práctica no cambia, y el coste por paquete
+// queda en el de ChaCha20-Poly1305.
+type xchachaAEAD struct {
+	key  [chacha20poly1305.KeySize]byte
+	salt [xSaltSize]byte
+	sub  atomic.Pointer[xchachaSubkey]
+}
+
+// xchachaSubkey es el AEAD con la subclave HChaCha20 de un prefijo de nonce.
+type xchachaSubkey struct {
+	prefix [4]byte
+	aead   cipher.AEAD
+}
+
+const xSaltSize = chacha20poly1305.NonceSizeX - chacha20poly1305.NonceSize
+
+func newXChaCha(key []byte) (cipher.AEAD, error) {
+	if len(key) != chacha20poly1305.KeySize {
+		return nil, fmt.Errorf("invalid key size: %d", len(key))
+	}
+	x := &xchachaAEAD{}
+	copy(x.key[:], key)
+	h, err := blake2s.New256(key)
+	if err != nil {
+		return nil, err
+	}
+	h.Write([]byte("taltun-xchacha-salt"))
+	copy(x.salt[:], h.Sum(nil))
+	return x, nil
+}
+
+// inner devuelve el AEAD de la subclave del nonce y el nonce interno
+// (4 ceros + los 8 últimos bytes del nonce), como define XChaCha20.
+func (x *xchachaAEAD) inner(nonce []byte) (cipher.AEAD, [chacha20poly1305.NonceSize]byte) {
+	if len(nonce) != chacha20poly1305.NonceSize {
+		panic("xchacha: incorrect nonce length")
+	}
+	var n [chacha20poly1305.NonceSize]byte
+	copy(n[4:], nonce[4:])
+
+	prefix := [4]byte(nonce[:4])
+	if s := x.sub.Load(); s != nil && s.prefix == prefix {
+		return s.aead, n
+	}
+	var hNonce [16]byte
+	copy(hNonce[:], x.salt[:])
+	copy(hNonce[xSaltSize:], prefix[:])
+	subkey, _ := chacha20.HChaCha20(x.key[:], hNonce[:])
+	aead, _ := chacha20poly1305.New(subkey)
+	x.sub.Store(&xchachaSubkey{prefix: prefix, aead: aead})
+	return aead, n
+}
+
+func (x *xchachaAEAD) NonceSize() int {
+	return chacha20poly1305.NonceSize
+}
+
+func (x *xchachaAEAD) Overhead() int {
+	return chacha20poly1305.Overhead
+}
+
+func (x *xchachaAEAD) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	aead, n := x.inner(nonce)
+	return aead.Seal(dst, n[:], plaintext, additionalData)
+}
+
+func (x *xchachaAEAD) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	aead, n := x.inner(nonce)
+	return aead.Open(dst, n[:], ciphertext, additionalData)
+}
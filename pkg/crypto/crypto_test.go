@@ -3,6 +3,8 @@ package crypto
 import (
 	"bytes"
 	"testing"
+
+	"golang.org/x/crypto/chacha20poly1305"
 )
 
 func TestKeyExchangeAndDerivation(t *testing.T) {
@@ -34,11 +36,11 @@ func TestKeyExchangeAndDerivation(t *testing.T) {
 	}
 
 	// 4. Derivación de clave de sesión (KDF)
-	aliceAEAD, err := DeriveSessionKey(aliceShared, "test-context")
+	aliceAEAD, _, err := DeriveSessionKeys(aliceShared, nil, nil, "test-context", nil, CipherChaCha20Poly1305, false)
 	if err != nil {
 		t.Fatal(err)
 	}
-	bobAEAD, err := DeriveSessionKey(bobShared, "test-context")
+	bobAEAD, _, err := DeriveSessionKeys(bobShared, nil, nil, "test-context", nil, CipherChaCha20Poly1305, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -58,3 +60,175 @@ func TestKeyExchangeAndDerivation(t *testing.T) {
 		t.Errorf("Message corrupted: got %s, want %s", decrypted, msg)
 	}
 }
+
+func TestPresharedKeyMixing(t *testing.T) {
+	alice, _ := GenerateKeyPair()
+	bob, _ := GenerateKeyPair()
+	shared, err := alice.SharedSecret(bob.Public[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	psk, err := GeneratePSK()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPSK, _ := GeneratePSK()
+
+	withPSK, _, _ := DeriveSessionKeys(shared, nil, &psk, "test-context", nil, CipherChaCha20Poly1305, false)
+	samePSK, _, _ := DeriveSessionKeys(shared, nil, &psk, "test-context", nil, CipherChaCha20Poly1305, false)
+	noPSK, _, _ := DeriveSessionKeys(shared, nil, nil, "test-context", nil, CipherChaCha20Poly1305, false)
+	wrongPSK, _, _ := DeriveSessionKeys(shared, nil, &otherPSK, "test-context", nil, CipherChaCha20Poly1305, false)
+
+	msg := []byte("Attack at dawn!")
+	nonce := make([]byte, withPSK.NonceSize())
+	encrypted := withPSK.Seal(nil, nonce, msg, nil)
+
+	if _, err := samePSK.Open(nil, nonce, encrypted, nil); err != nil {
+		t.Fatalf("Same PSK failed to decrypt: %v", err)
+	}
+	// Sin la PSK (o con otra) el secreto ECDH por sí solo no basta.
+	if _, err := noPSK.Open(nil, nonce, encrypted, nil); err == nil {
+		t.Errorf("Decrypted without PSK")
+	}
+	if _, err := wrongPSK.Open(nil, nonce, encrypted, nil); err == nil {
+		t.Errorf("Decrypted with wrong PSK")
+	}
+}
+
+func TestHybridKEM(t *testing.T) {
+	alice, _ := GenerateKeyPair()
+	bob, _ := GenerateKeyPair()
+	shared, _ := alice.SharedSecret(bob.Public[:])
+
+	// Alice (iniciador) envía su clave ML-KEM; Bob encapsula y responde.
+	kem, err := GenerateKEMKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kem.PublicBytes()) != KEMPublicSize {
+		t.Fatalf("Wrong KEM public size: %d", len(kem.PublicBytes()))
+	}
+	bobSecret, ct, err := KEMEncapsulate(kem.PublicBytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliceSecret, err := kem.Decapsulate(ct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(aliceSecret, bobSecret) {
+		t.Fatalf("KEM secret mismatch")
+	}
+
+	aliceAEAD, _, _ := DeriveSessionKeys(shared, aliceSecret, nil, "test-context", nil, CipherChaCha20Poly1305, false)
+	bobAEAD, _, _ := DeriveSessionKeys(shared, bobSecret, nil, "test-context", nil, CipherChaCha20Poly1305, false)
+	classicAEAD, _, _ := DeriveSessionKeys(shared, nil, nil, "test-context", nil, CipherChaCha20Poly1305, false)
+
+	msg := []byte("Attack at dawn!")
+	nonce := make([]byte, aliceAEAD.NonceSize())
+	encrypted := aliceAEAD.Seal(nil, nonce, msg, nil)
+
+	if _, err := bobAEAD.Open(nil, nonce, encrypted, nil); err != nil {
+		t.Fatalf("Hybrid decryption failed: %v", err)
+	}
+	// El secreto clásico solo no debe abrir una sesión híbrida.
+	if _, err := classicAEAD.Open(nil, nonce, encrypted, nil); err == nil {
+		t.Errorf("Classic key opened hybrid session")
+	}
+}
+
+func TestCipherSuites(t *testing.T) {
+	var shared [KeySize]byte
+	msg := []byte("Attack at dawn!")
+	nonce := make([]byte, 12)
+	nonce[11] = 1
+
+	var sealed [][]byte
+	for _, suite := range []CipherSuite{CipherChaCha20Poly1305, CipherAES256GCM, CipherXChaCha20Poly1305} {
+		aead, _, err := DeriveSessionKeys(shared, nil, nil, "test-context", nil, suite, false)
+		if err != nil {
+			t.Fatalf("%v: %v", suite, err)
+		}
+		// El protocolo reserva 12 bytes de nonce en la cabecera para todas.
+		if aead.NonceSize() != 12 || aead.Overhead() != 16 {
+			t.Fatalf("%v: nonce=%d overhead=%d", suite, aead.NonceSize(), aead.Overhead())
+		}
+		ct := aead.Seal(nil, nonce, msg, nil)
+		pt, err := aead.Open(nil, nonce, ct, nil)
+		if err != nil || string(pt) != string(msg) {
+			t.Fatalf("%v: round trip failed: %v", suite, err)
+		}
+		sealed = append(sealed, ct)
+
+		if name, err := ParseCipherSuite(suite.String()); err != nil || name != suite {
+			t.Errorf("ParseCipherSuite(%s) = %v, %v", suite, name, err)
+		}
+	}
+
+	// Misma entrada, suites distintas: ciphertexts (y claves) distintos.
+	if bytes.Equal(sealed[0], sealed[1]) || bytes.Equal(sealed[0], sealed[2]) || bytes.Equal(sealed[1], sealed[2]) {
+		t.Errorf("Cipher suites share output")
+	}
+}
+
+func TestDirectionalKeys(t *testing.T) {
+	var shared [KeySize]byte
+	transcript := []byte("init ext | resp ext")
+	msg := []byte("Attack at dawn!")
+	nonce := make([]byte, 12)
+
+	for _, suite := range []CipherSuite{CipherChaCha20Poly1305, CipherAES256GCM, CipherXChaCha20Poly1305} {
+		iSend, iRecv, err := DeriveSessionKeys(shared, nil, nil, "test-context", transcript, suite, true)
+		if err != nil {
+			t.Fatalf("%v: %v", suite, err)
+		}
+		rSend, rRecv, _ := DeriveSessionKeys(shared, nil, nil, "test-context", transcript, suite, false)
+
+		// Cada sentido abre con la clave del otro lado...
+		if _, err := rRecv.Open(nil, nonce, iSend.Seal(nil, nonce, msg, nil), nil); err != nil {
+			t.Errorf("%v: initiator -> responder failed: %v", suite, err)
+		}
+		if _, err := iRecv.Open(nil, nonce, rSend.Seal(nil, nonce, msg, nil), nil); err != nil {
+			t.Errorf("%v: responder -> initiator failed: %v", suite, err)
+		}
+		// ...y el mismo nonce en los dos sentidos no repite keystream.
+		if bytes.Equal(iSend.Seal(nil, nonce, msg, nil), rSend.Seal(nil, nonce, msg, nil)) {
+			t.Errorf("%v: both directions share a key", suite)
+		}
+	}
+}
+
+func TestXChaChaSalt(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, KeySize)
+	aead, err := NewAEAD(CipherXChaCha20Poly1305, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := aead.(*xchachaAEAD)
+	ref, _ := chacha20poly1305.NewX(key)
+	msg := []byte("Attack at dawn!")
+
+	// Es XChaCha20-Poly1305 con nonce salt ‖ nonce, también al cambiar el
+	// prefijo (subclave cacheada) y volver al anterior.
+	for _, nonce := range [][]byte{
+		{0xCA, 0xFE, 0xBA, 0xBE, 0, 0, 0, 0, 0, 0, 0, 1},
+		{0xCA, 0xFE, 0xBA, 0xBE, 0, 0, 0, 0, 0, 0, 0, 2},
+		{1, 2, 3, 4, 0, 0, 0, 0, 0, 0, 0, 2},
+		{0xCA, 0xFE, 0xBA, 0xBE, 0, 0, 0, 0, 0, 0, 0, 3},
+	} {
+		want := ref.Seal(nil, append(x.salt[:], nonce...), msg, []byte("ad"))
+		if got := aead.Seal(nil, nonce, msg, []byte("ad")); !bytes.Equal(got, want) {
+			t.Fatalf("nonce % x: output differs from XChaCha20-Poly1305", nonce)
+		}
+		if _, err := aead.Open(nil, nonce, want, []byte("ad")); err != nil {
+			t.Fatalf("nonce % x: open failed: %v", nonce, err)
+		}
+	}
+
+	// El salt depende de la clave: no es un relleno fijo.
+	other, _ := NewAEAD(CipherXChaCha20Poly1305, bytes.Repeat([]byte{8}, KeySize))
+	if other.(*xchachaAEAD).salt == x.salt {
+		t.Errorf("Salt does not depend on the key")
+	}
+}
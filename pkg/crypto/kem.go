@@ -0,0 +1,51 @@
+package crypto
+
+import (
+	"crypto/mlkem"
+	"fmt"
+)
+
+// ML-KEM-768 (FIPS 203) para el intercambio híbrido: su secreto se mezcla con
+// el de Curve25519 en DeriveSessionKey, así que la sesión aguanta mientras
+// cualquiera de los dos siga sin romperse.
+const (
+	KEMPublicSize     = mlkem.EncapsulationKeySize768
+	KEMCiphertextSize = mlkem.CiphertextSize768
+)
+
+// KEMKeyPair es la clave efímera del iniciador de un handshake híbrido.
+type KEMKeyPair struct {
+	dk *mlkem.DecapsulationKey768
+}
+
+// GenerateKEMKeyPair crea una clave de decapsulación ML-KEM-768 nueva.
+func GenerateKEMKeyPair() (*KEMKeyPair, error) {
+	dk, err := mlkem.GenerateKey768()
+	if err != nil {
+		return nil, fmt.Errorf("mlkem keygen: %v", err)
+	}
+	return &KEMKeyPair{dk: dk}, nil
+}
+
+// PublicBytes devuelve la clave de encapsulación que se envía en el Init.
+func (k *KEMKeyPair) PublicBytes() []byte {
+	return k.dk.EncapsulationKey().Bytes()
+}
+
+// Decapsulate recupera el secreto a partir del ciphertext del Resp.
+// Ojo: ML-KEM no falla con un ciphertext ajeno (rechazo implícito), devuelve
+// un secreto distinto. El error solo indica un tamaño inválido.
+func (k *KEMKeyPair) Decapsulate(ciphertext []byte) ([]byte, error) {
+	return k.dk.Decapsulate(ciphertext)
+}
+
+// KEMEncapsulate genera un secreto para la clave pública del iniciador.
+// Devuelve el secreto y el ciphertext que hay que enviarle.
+func KEMEncapsulate(publicKey []byte) (secret, ciphertext []byte, err error) {
+	ek, err := mlkem.NewEncapsulationKey768(publicKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid mlkem key: %v", err)
+	}
+	secret, ciphertext = ek.Encapsulate()
+	return secret, ciphertext, nil
+}
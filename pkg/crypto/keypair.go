@@ -7,7 +7,6 @@ import (
 	"io"
 
 	"golang.org/x/crypto/blake2s"
-	"golang.org/x/crypto/chacha20poly1305"
 	"golang.org/x/crypto/curve25519"
 )
 
@@ -56,18 +55,96 @@ func (kp *KeyPair) SharedSecret(peerPublic []byte) ([KeySize]byte, error) {
 	return secret, nil
 }
 
-// DeriveSessionKey convierte el secreto compartido ECDH en una clave AEAD usando KDF (Blake2s).
+// GeneratePSK crea una clave pre-compartida aleatoria (32 bytes).
+func GeneratePSK() ([KeySize]byte, error) {
+	var psk [KeySize]byte
+	if _, err := io.ReadFull(rand.Reader, psk[:]); err != nil {
+		return psk, fmt.Errorf("rng fail: %v", err)
+	}
+	return psk, nil
+}
+
+// HandshakeAuthKey deriva la clave que autentica los handshakes v2+ entre dos
+// identidades estáticas. Como la de sesión, depende de la PSK si la hay.
+func HandshakeAuthKey(sharedSecret [KeySize]byte, psk *[KeySize]byte) ([KeySize]byte, error) {
+	var key [KeySize]byte
+	var macKey []byte
+	if psk != nil {
+		macKey = psk[:]
+	}
+	kdf, err := blake2s.New256(macKey)
+	if err != nil {
+		return key, err
+	}
+	kdf.Write([]byte("taltun-handshake-auth"))
+	kdf.Write(sharedSecret[:])
+	kdf.Sum(key[:0])
+	return key, nil
+}
+
+// DeriveSessionKeys convierte el secreto compartido ECDH en las claves AEAD de
+// la sesión usando KDF (Blake2s).
 // Esto es crucial: No usar el output de Curve25519 directamente como clave simétrica.
-func DeriveSessionKey(sharedSecret [KeySize]byte, context string) (cipher.AEAD, error) {
+//
+// kemSecret (opcional) es el secreto ML-KEM de un handshake híbrido. Sin él la
+// clave resultante es idéntica a la de los nodos que no lo soportan.
+//
+// Si psk no es nil se usa como clave del Blake2s (modo MAC): la clave de sesión
+// depende entonces también de un secreto simétrico, y quien grabe el tráfico y
+// rompa Curve25519 en el futuro (ordenador cuántico) sigue sin poder descifrarlo.
+//
+// transcript (v2+) son las extensiones del Init y el Resp: lo negociado queda
+// ligado a la clave. Con él cada sentido tiene su propia clave (initiator es
+// nuestro papel en el handshake): los dos lados numeran sus nonces por
+// separado y con una clave común acabarían repitiendo nonce. Los nodos legacy
+// no tienen transcripción (nil) y comparten una sola clave (send == recv).
+//
+// La suite también entra en el KDF (salvo ChaCha20, por compatibilidad), para
+// que dos suites distintas nunca compartan clave.
+func DeriveSessionKeys(sharedSecret [KeySize]byte, kemSecret []byte, psk *[KeySize]byte, context string, transcript []byte, suite CipherSuite, initiator bool) (send, recv cipher.AEAD, err error) {
 	// KDF simple usando Blake2s
-	kdf, err := blake2s.New256(nil)
+	var macKey []byte
+	if psk != nil {
+		macKey = psk[:]
+	}
+	kdf, err := blake2s.New256(macKey)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	kdf.Write(sharedSecret[:])
-	kdf.Write([]byte(context)) // Contexto para separar claves si fuera necesario (ej. Tx vs Rx)
-	
+	kdf.Write(kemSecret)
+	kdf.Write([]byte(context))
+	kdf.Write(transcript)
+	if suite != CipherChaCha20Poly1305 {
+		kdf.Write([]byte{byte(suite)})
+	}
 	key := kdf.Sum(nil)
 
-	return chacha20poly1305.New(key)
+	if transcript == nil {
+		aead, err := NewAEAD(suite, key)
+		return aead, aead, err
+	}
+
+	i2r, err := directionalAEAD(suite, key, "taltun-initiator-to-responder")
+	if err != nil {
+		return nil, nil, err
+	}
+	r2i, err := directionalAEAD(suite, key, "taltun-responder-to-initiator")
+	if err != nil {
+		return nil, nil, err
+	}
+	if initiator {
+		return i2r, r2i, nil
+	}
+	return r2i, i2r, nil
+}
+
+// directionalAEAD deriva de la clave de sesión la de un sentido.
+func directionalAEAD(suite CipherSuite, key []byte, label string) (cipher.AEAD, error) {
+	kdf, err := blake2s.New256(key)
+	if err != nil {
+		return nil, err
+	}
+	kdf.Write([]byte(label))
+	return NewAEAD(suite, kdf.Sum(nil))
 }
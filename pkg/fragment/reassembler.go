@@ -0,0 +1,167 @@
+package fragment
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Soyunomas/taltun/pkg/pool"
+	"github.com/Soyunomas/taltun/pkg/protocol"
+)
+
+// Límites del reensamblado: un mensaje incompleto caduca a los Timeout y la
+// memoria retenida nunca supera MaxPending mensajes ni MaxBytes en trozos. Al
+// llegar a un límite se descarta el mensaje pendiente más antiguo.
+const (
+	Timeout    = 2 * time.Second
+	MaxPending = 128
+	MaxBytes   = 4 << 20
+)
+
+type key struct {
+	sender uint32
+	id     uint32
+}
+
+// entry es un mensaje a medio reensamblar. Cada trozo se copia a un buffer
+// del pool de su tamaño.
+type entry struct {
+	count   uint8
+	have    uint32 // Bitmap de trozos recibidos
+	parts   [protocol.MaxFragments]*pool.Buff
+	lens    [protocol.MaxFragments]int
+	bytes   int
+	started time.Time
+}
+
+// Stats son los contadores acumulados del reensamblado.
+type Stats struct {
+	Pending     int
+	Reassembled uint64
+	Expired     uint64 // Incompletos que caducaron
+	Evicted     uint64 // Descartados por los límites de memoria
+}
+
+// Reassembler junta los trozos de los mensajes MsgTypeFragment. Es seguro
+// para uso concurrente (los workers RX comparten uno).
+type Reassembler struct {
+	mu      sync.Mutex
+	pending map[key]*entry
+	bytes   int
+	stats   Stats
+}
+
+func NewReassembler() *Reassembler {
+	return &Reassembler{pending: make(map[key]*entry)}
+}
+
+// Add guarda el trozo index (de count) del mensaje id de sender. Cuando el
+// mensaje está completo lo devuelve reensamblado en un buffer del pool (el
+// llamante lo devuelve con pool.Put) junto a su longitud.
+func (r *Reassembler) Add(sender, id uint32, index, count uint8, data []byte, now time.Time) (*pool.Buff, int, bool) {
+	if count < 2 || count > protocol.MaxFragments || index >= count ||
+		len(data) == 0 || len(data) > pool.MaxSize/int(count) {
+		return nil, 0, false
+	}
+	k := key{sender, id}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e := r.pending[k]
+	if e == nil {
+		for len(r.pending) >= MaxPending {
+			r.evictOldest()
+		}
+		e = &entry{count: count, started: now}
+		r.pending[k] = e
+	} else if e.count != count {
+		r.drop(k, e) // Trozos incoherentes: el mensaje no puede ser válido
+		return nil, 0, false
+	}
+	if e.have&(1<<index) != 0 {
+		return nil, 0, false // Duplicado
+	}
+
+	for r.bytes+len(data) > MaxBytes && len(r.pending) > 1 {
+		r.evictOldest()
+	}
+	if r.pending[k] == nil {
+		return nil, 0, false // El más antiguo era este
+	}
+
+	b := pool.GetSize(len(data))
+	e.parts[index] = b
+	e.lens[index] = copy(b.B, data)
+	e.have |= 1 << index
+	e.bytes += len(data)
+	r.bytes += len(data)
+
+	if e.have != 1<<count-1 {
+		return nil, 0, false
+	}
+
+	if e.bytes > pool.MaxSize {
+		r.drop(k, e)
+		return nil, 0, false
+	}
+	out := pool.GetSize(e.bytes)
+	n := 0
+	for i := 0; i < int(count); i++ {
+		n += copy(out.B[n:], e.parts[i].B[:e.lens[i]])
+	}
+	r.drop(k, e)
+	r.stats.Reassembled++
+	return out, n, true
+}
+
+// Expire descarta los mensajes incompletos de más de Timeout. Devuelve
+// cuántos.
+func (r *Reassembler) Expire(now time.Time) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for k, e := range r.pending {
+		if now.Sub(e.started) > Timeout {
+			r.drop(k, e)
+			n++
+		}
+	}
+	r.stats.Expired += uint64(n)
+	return n
+}
+
+// Stats devuelve los contadores del reensamblado.
+func (r *Reassembler) Stats() Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.stats
+	s.Pending = len(r.pending)
+	return s
+}
+
+// evictOldest descarta el mensaje pendiente más antiguo. Requiere r.mu.
+func (r *Reassembler) evictOldest() {
+	var oldestKey key
+	var oldest *entry
+	for k, e := range r.pending {
+		if oldest == nil || e.started.Before(oldest.started) {
+			oldestKey, oldest = k, e
+		}
+	}
+	if oldest != nil {
+		r.drop(oldestKey, oldest)
+		r.stats.Evicted++
+	}
+}
+
+// drop libera un mensaje pendiente. Requiere r.mu.
+func (r *Reassembler) drop(k key, e *entry) {
+	for i := range e.parts {
+		if e.parts[i] != nil {
+			pool.Put(e.parts[i])
+		}
+	}
+	r.bytes -= e.bytes
+	delete(r.pending, k)
+}
@@ -0,0 +1,67 @@
+package fragment
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/Soyunomas/taltun/pkg/pool"
+)
+
+func TestReassembleOutOfOrder(t *testing.T) {
+	r := NewReassembler()
+	now := time.Now()
+	msg := bytes.Repeat([]byte("taltun"), 500)
+	parts := [][]byte{msg[:1200], msg[1200:2400], msg[2400:]}
+
+	for _, i := range []int{2, 0} {
+		if _, _, ok := r.Add(1, 7, uint8(i), 3, parts[i], now); ok {
+			t.Fatalf("Complete after fragment %d", i)
+		}
+	}
+	if _, _, ok := r.Add(1, 7, 0, 3, parts[0], now); ok {
+		t.Fatal("Duplicate fragment completed the message")
+	}
+	buf, n, ok := r.Add(1, 7, 1, 3, parts[1], now)
+	if !ok {
+		t.Fatal("Message not reassembled")
+	}
+	if !bytes.Equal(buf.B[:n], msg) {
+		t.Errorf("Reassembled message differs")
+	}
+	pool.Put(buf)
+
+	if s := r.Stats(); s.Pending != 0 || s.Reassembled != 1 || r.bytes != 0 {
+		t.Errorf("Unexpected state after reassembly: %+v bytes=%d", s, r.bytes)
+	}
+}
+
+func TestReassemblerLimits(t *testing.T) {
+	r := NewReassembler()
+	now := time.Now()
+	chunk := make([]byte, 1000)
+
+	// Los incompletos caducan
+	r.Add(1, 1, 0, 2, chunk, now)
+	if n := r.Expire(now.Add(Timeout / 2)); n != 0 {
+		t.Errorf("Expired %d messages too early", n)
+	}
+	if n := r.Expire(now.Add(Timeout + time.Millisecond)); n != 1 {
+		t.Errorf("Expected 1 expired message, got %d", n)
+	}
+
+	// Nunca más de MaxPending: cae el más antiguo
+	for i := 0; i < MaxPending+10; i++ {
+		r.Add(2, uint32(i), 0, 2, chunk, now.Add(time.Duration(i)))
+	}
+	s := r.Stats()
+	if s.Pending != MaxPending || s.Evicted != 10 {
+		t.Errorf("Pending=%d Evicted=%d", s.Pending, s.Evicted)
+	}
+	if r.bytes != MaxPending*len(chunk) {
+		t.Errorf("Byte accounting off: %d", r.bytes)
+	}
+	if _, ok := r.pending[key{2, 0}]; ok {
+		t.Errorf("Oldest message was not evicted")
+	}
+}
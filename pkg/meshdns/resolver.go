@@ -0,0 +1,289 @@
+package meshdns
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// TTL de las respuestas de la malla. Corto: las VIPs no cambian, pero los
+	// peers pueden desaparecer de la configuración.
+	recordTTL = 60
+
+	maxPacketSize   = 1232 // Recomendación DNS Flag Day 2020 (sin fragmentación)
+	upstreamTimeout = 2 * time.Second
+
+	// Las respuestas de los upstreams pueden ser mayores que nuestras consultas:
+	// con EDNS el cliente puede pedir hasta 64 KB, y truncarlas las corrompe.
+	maxUpstreamSize = 64 << 10
+
+	// Consultas atendidas a la vez. Con todas ocupadas (upstreams lentos o una
+	// ráfaga) las nuevas se descartan: el cliente reintenta.
+	maxInflight = 256
+)
+
+var upstreamBufs = sync.Pool{New: func() any { return new([maxUpstreamSize]byte) }}
+
+type hostMap = map[string]net.IP
+
+// Resolver es un servidor DNS mínimo de "split-DNS":
+//   - <nombre>.<dominio> se responde desde la tabla de peers.
+//   - Todo lo demás se reenvía tal cual a los upstreams.
+//
+// La tabla se lee sin locks (Copy-On-Write con atomic.Pointer, como el mapa de
+// peers del engine): las escrituras solo ocurren al añadir peers.
+type Resolver struct {
+	domain    string // FQDN en minúsculas, con punto final ("mesh.")
+	upstreams []string
+
+	// FwMark se aplica (SO_MARK) a los sockets hacia los upstreams, para que
+	// en modo Full Tunnel no vuelvan a entrar en el túnel. 0 = sin marca.
+	FwMark uint32
+
+	inflight chan struct{}
+
+	hosts   atomic.Pointer[hostMap]
+	hostsMu sync.Mutex
+
+	connMu sync.Mutex
+	conn   net.PacketConn
+	closed atomic.Bool
+}
+
+// New crea un Resolver para el dominio de la malla (ej. "mesh" o "vpn.corp").
+// Los upstreams son direcciones "ip:puerto"; sin ellos solo se responde la malla.
+func New(domain string, upstreams []string) *Resolver {
+	r := &Resolver{
+		domain:    canonical(domain),
+		upstreams: upstreams,
+		inflight:  make(chan struct{}, maxInflight),
+	}
+	empty := make(hostMap)
+	r.hosts.Store(&empty)
+	return r
+}
+
+// SetHost registra (o reemplaza) un nombre de la malla.
+func (r *Resolver) SetHost(name string, ip net.IP) {
+	r.hostsMu.Lock()
+	defer r.hostsMu.Unlock()
+
+	old := *r.hosts.Load()
+	next := make(hostMap, len(old)+1)
+	for k, v := range old {
+		next[k] = v
+	}
+	next[canonical(name)+r.domain] = ip
+	r.hosts.Store(&next)
+}
+
+// ListenAndServe escucha en addr (normalmente la VIP local, puerto 53) y atiende
+// consultas hasta que se llame a Close.
+func (r *Resolver) ListenAndServe(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	return r.Serve(conn)
+}
+
+// Serve atiende consultas sobre una conexión ya abierta.
+func (r *Resolver) Serve(conn net.PacketConn) error {
+	r.connMu.Lock()
+	r.conn = conn
+	r.connMu.Unlock()
+	if r.closed.Load() {
+		return conn.Close()
+	}
+	buf := make([]byte, maxPacketSize)
+
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if r.closed.Load() || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		select {
+		case r.inflight <- struct{}{}:
+		default:
+			continue
+		}
+		query := make([]byte, n)
+		copy(query, buf[:n])
+		go func() {
+			defer func() { <-r.inflight }()
+			r.handle(conn, addr, query)
+		}()
+	}
+}
+
+// Close detiene el servidor.
+func (r *Resolver) Close() error {
+	if r.closed.Swap(true) {
+		return nil
+	}
+	r.connMu.Lock()
+	defer r.connMu.Unlock()
+	if r.conn == nil {
+		return nil
+	}
+	return r.conn.Close()
+}
+
+func (r *Resolver) handle(conn net.PacketConn, addr net.Addr, query []byte) {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(query)
+	if err != nil || hdr.Response {
+		return
+	}
+	q, err := p.Question()
+	if err != nil {
+		return
+	}
+
+	name := strings.ToLower(q.Name.String())
+	if name == r.domain || strings.HasSuffix(name, "."+r.domain) {
+		if resp, err := r.answerLocal(hdr, q, name); err == nil {
+			conn.WriteTo(resp, addr)
+		}
+		return
+	}
+
+	buf := upstreamBufs.Get().(*[maxUpstreamSize]byte)
+	defer upstreamBufs.Put(buf)
+	if resp := r.forward(query, buf[:]); resp != nil {
+		conn.WriteTo(resp, addr)
+	}
+}
+
+// answerLocal construye la respuesta autoritativa para un nombre de la malla.
+func (r *Resolver) answerLocal(qh dnsmessage.Header, q dnsmessage.Question, name string) ([]byte, error) {
+	ip, found := (*r.hosts.Load())[name]
+
+	hdr := dnsmessage.Header{
+		ID:                 qh.ID,
+		Response:           true,
+		Authoritative:      true,
+		RecursionDesired:   qh.RecursionDesired,
+		RecursionAvailable: len(r.upstreams) > 0,
+	}
+	if !found && name != r.domain {
+		hdr.RCode = dnsmessage.RCodeNameError
+	}
+
+	b := dnsmessage.NewBuilder(make([]byte, 0, 128), hdr)
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(q); err != nil {
+		return nil, err
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+
+	// Solo hay A (las VIPs son IPv4). Para AAAA y otros tipos respondemos
+	// NOERROR sin datos, para que el cliente no espere en vano.
+	if found && (q.Type == dnsmessage.TypeA || q.Type == dnsmessage.TypeALL) {
+		var a dnsmessage.AResource
+		copy(a.A[:], ip.To4())
+		rh := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: recordTTL}
+		if err := b.AResource(rh, a); err != nil {
+			return nil, err
+		}
+	}
+
+	return b.Finish()
+}
+
+// forward reenvía la consulta al primer upstream que conteste y deja la
+// respuesta en buf.
+func (r *Resolver) forward(query, buf []byte) []byte {
+	d := net.Dialer{Control: r.markControl}
+	for _, up := range r.upstreams {
+		c, err := d.Dial("udp", up)
+		if err != nil {
+			continue
+		}
+		c.SetDeadline(time.Now().Add(upstreamTimeout))
+		_, err = c.Write(query)
+		if err == nil {
+			var n int
+			n, err = c.Read(buf)
+			if err == nil {
+				c.Close()
+				return buf[:n]
+			}
+		}
+		c.Close()
+	}
+	return nil
+}
+
+// markControl aplica FwMark al socket antes de conectar.
+func (r *Resolver) markControl(network, address string, c syscall.RawConn) error {
+	if r.FwMark == 0 {
+		return nil
+	}
+	var opErr error
+	err := c.Control(func(fd uintptr) {
+		opErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_MARK, int(r.FwMark))
+	})
+	if err != nil {
+		return err
+	}
+	return opErr
+}
+
+// SystemUpstreams lee los "nameserver" de /etc/resolv.conf, descartando los
+// indicados en exclude (p.ej. nuestra propia VIP). Devuelve "ip:53".
+func SystemUpstreams(exclude ...net.IP) []string {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var out []string
+	sc := bufio.NewScanner(f)
+next:
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+		ip := net.ParseIP(fields[1])
+		if ip == nil {
+			continue
+		}
+		for _, ex := range exclude {
+			if ip.Equal(ex) {
+				continue next
+			}
+		}
+		out = append(out, net.JoinHostPort(ip.String(), "53"))
+	}
+	return out
+}
+
+func canonical(name string) string {
+	name = strings.ToLower(strings.Trim(name, "."))
+	if name == "" {
+		return ""
+	}
+	return name + "."
+}
@@ -0,0 +1,132 @@
+package meshdns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// startResolver levanta un Resolver en loopback y devuelve su dirección.
+func startResolver(t *testing.T, r *Resolver) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Serve(conn)
+	t.Cleanup(func() { r.Close() })
+	return conn.LocalAddr().String()
+}
+
+// client devuelve un resolver de Go que solo habla con addr (cliente DNS in-process).
+func client(addr string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", addr)
+		},
+	}
+}
+
+func TestResolverMeshNames(t *testing.T) {
+	r := New("mesh", nil)
+	r.SetHost("office", net.ParseIP("10.0.0.2"))
+	r.SetHost("Laptop", net.ParseIP("10.0.0.3"))
+
+	c := client(startResolver(t, r))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ips, err := c.LookupIP(ctx, "ip4", "office.mesh")
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if len(ips) != 1 || !ips[0].Equal(net.ParseIP("10.0.0.2")) {
+		t.Errorf("Wrong answer: %v", ips)
+	}
+
+	// Los nombres no distinguen mayúsculas
+	ips, err = c.LookupIP(ctx, "ip4", "LAPTOP.Mesh.")
+	if err != nil || len(ips) != 1 || !ips[0].Equal(net.ParseIP("10.0.0.3")) {
+		t.Errorf("Case-insensitive lookup failed: %v %v", ips, err)
+	}
+
+	_, err = c.LookupIP(ctx, "ip4", "missing.mesh")
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Errorf("Expected NXDOMAIN, got %v", err)
+	}
+}
+
+func TestResolverForwardsUpstream(t *testing.T) {
+	// El "upstream" es otro Resolver autoritativo para example.
+	upstream := New("example", nil)
+	upstream.SetHost("www", net.ParseIP("192.0.2.10"))
+	upAddr := startResolver(t, upstream)
+
+	r := New("mesh", []string{upAddr})
+	c := client(startResolver(t, r))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ips, err := c.LookupIP(ctx, "ip4", "www.example")
+	if err != nil {
+		t.Fatalf("Forwarded lookup failed: %v", err)
+	}
+	if len(ips) != 1 || !ips[0].Equal(net.ParseIP("192.0.2.10")) {
+		t.Errorf("Wrong forwarded answer: %v", ips)
+	}
+}
+
+func TestResolverForwardsLargeReplies(t *testing.T) {
+	// Upstream falso que contesta con una respuesta EDNS de 4000 bytes.
+	up, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer up.Close()
+	go func() {
+		buf := make([]byte, 512)
+		n, addr, err := up.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		reply := make([]byte, 4000)
+		copy(reply, buf[:n])
+		up.WriteTo(reply, addr)
+	}()
+
+	r := New("mesh", []string{up.LocalAddr().String()})
+	c, err := net.Dial("udp", startResolver(t, r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+
+	q := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: 7, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName("big.example."), Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET}},
+	}
+	query, err := q.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write(query); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 8192)
+	n, err := c.Read(buf)
+	if err != nil {
+		t.Fatalf("No reply: %v", err)
+	}
+	if n != 4000 {
+		t.Errorf("Reply of %d bytes, want 4000", n)
+	}
+}
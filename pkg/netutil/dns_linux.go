@@ -0,0 +1,215 @@
+package netutil
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	resolvConfPath   = "/etc/resolv.conf"
+	resolvBackupPath = "/etc/resolv.conf.taltun-backup"
+)
+
+// DNSConfig son los ajustes de resolución que se aplican mientras el túnel está activo.
+type DNSConfig struct {
+	Servers []net.IP
+	Search  []string
+
+	// DefaultRoute hace que todas las consultas vayan a estos servidores
+	// (modo Full Tunnel). Sin él, systemd-resolved solo los usa para Search.
+	DefaultRoute bool
+}
+
+// DNSManager aplica la configuración DNS al sistema y la restaura al salir.
+// Elige el backend disponible en este orden:
+//  1. systemd-resolved (vía resolvectl, que habla D-Bus con el daemon)
+//  2. resolvconf (Debian/openresolv)
+//  3. Gestión directa de /etc/resolv.conf (con copia de seguridad)
+type DNSManager struct {
+	mu        sync.Mutex
+	ifaceName string
+	backend   string // "" si no hay nada aplicado
+}
+
+// NewDNSManager crea un gestor DNS para la interfaz indicada.
+func NewDNSManager(ifaceName string) *DNSManager {
+	return &DNSManager{ifaceName: ifaceName}
+}
+
+// Apply configura los servidores y dominios de búsqueda.
+// Llamadas sucesivas reemplazan la configuración anterior.
+func (m *DNSManager) Apply(cfg DNSConfig) error {
+	if len(cfg.Servers) == 0 {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case usesSystemdResolved():
+		m.backend = "systemd-resolved"
+		return m.applyResolved(cfg)
+	case hasCommand("resolvconf"):
+		m.backend = "resolvconf"
+		return m.applyResolvconf(cfg)
+	default:
+		m.backend = "file"
+		return m.applyFile(cfg)
+	}
+}
+
+// Backend devuelve el mecanismo usado ("" si no se aplicó nada).
+func (m *DNSManager) Backend() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.backend
+}
+
+// Restore deshace lo aplicado. Es idempotente.
+func (m *DNSManager) Restore() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	backend := m.backend
+	m.backend = ""
+
+	switch backend {
+	case "systemd-resolved":
+		// Si la TUN ya desapareció, resolved olvidó el enlace por su cuenta.
+		if _, err := net.InterfaceByName(m.ifaceName); err != nil {
+			return nil
+		}
+		return run(nil, "resolvectl", "revert", m.ifaceName)
+	case "resolvconf":
+		return run(nil, "resolvconf", "-d", m.resolvconfName(), "-f")
+	case "file":
+		return RestoreResolvConf()
+	}
+	return nil
+}
+
+// RestoreResolvConf recupera /etc/resolv.conf desde la copia de seguridad si
+// existe (p.ej. tras un crash con el backend "file"). Sin copia no hace nada.
+func RestoreResolvConf() error {
+	orig, err := os.ReadFile(resolvBackupPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error leyendo backup DNS: %v", err)
+	}
+	if err := writeFileAtomic(resolvConfPath, orig); err != nil {
+		return fmt.Errorf("error restaurando %s: %v", resolvConfPath, err)
+	}
+	return os.Remove(resolvBackupPath)
+}
+
+func (m *DNSManager) applyResolved(cfg DNSConfig) error {
+	args := []string{"dns", m.ifaceName}
+	for _, ip := range cfg.Servers {
+		args = append(args, ip.String())
+	}
+	if err := run(nil, "resolvectl", args...); err != nil {
+		return err
+	}
+
+	domains := []string{"domain", m.ifaceName}
+	domains = append(domains, cfg.Search...)
+	if cfg.DefaultRoute {
+		// "~." = dominio de enrutamiento raíz: todas las consultas por este enlace.
+		domains = append(domains, "~.")
+	}
+	if len(domains) > 2 {
+		if err := run(nil, "resolvectl", domains...); err != nil {
+			return err
+		}
+	}
+
+	if cfg.DefaultRoute {
+		return run(nil, "resolvectl", "default-route", m.ifaceName, "true")
+	}
+	return nil
+}
+
+func (m *DNSManager) applyResolvconf(cfg DNSConfig) error {
+	// -m 0: máxima prioridad; -x: exclusivo (ignorar el resto de interfaces).
+	return run(renderResolvConf(cfg), "resolvconf", "-a", m.resolvconfName(), "-m", "0", "-x")
+}
+
+func (m *DNSManager) applyFile(cfg DNSConfig) error {
+	// Solo guardamos el original la primera vez: si ya hay backup es nuestro.
+	if _, err := os.Stat(resolvBackupPath); os.IsNotExist(err) {
+		orig, err := os.ReadFile(resolvConfPath)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error leyendo %s: %v", resolvConfPath, err)
+		}
+		if err := os.WriteFile(resolvBackupPath, orig, 0644); err != nil {
+			return fmt.Errorf("error guardando backup DNS: %v", err)
+		}
+	}
+	return writeFileAtomic(resolvConfPath, renderResolvConf(cfg))
+}
+
+func (m *DNSManager) resolvconfName() string {
+	return "tun." + m.ifaceName
+}
+
+func renderResolvConf(cfg DNSConfig) []byte {
+	var b bytes.Buffer
+	b.WriteString("# Generado por Taltun. Se restaurará al cerrar el túnel.\n")
+	for _, ip := range cfg.Servers {
+		fmt.Fprintf(&b, "nameserver %s\n", ip)
+	}
+	if len(cfg.Search) > 0 {
+		fmt.Fprintf(&b, "search %s\n", strings.Join(cfg.Search, " "))
+	}
+	return b.Bytes()
+}
+
+// usesSystemdResolved detecta si /etc/resolv.conf lo gestiona systemd-resolved.
+func usesSystemdResolved() bool {
+	if !hasCommand("resolvectl") {
+		return false
+	}
+	target, err := filepath.EvalSymlinks(resolvConfPath)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(target, "/run/systemd/resolve/")
+}
+
+func hasCommand(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+func run(stdin []byte, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s %s: %v (%s)", name, strings.Join(args, " "), err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// writeFileAtomic escribe en un temporal y renombra. Si path es un symlink
+// escribimos sobre el archivo al que apunta para no romper el enlace.
+func writeFileAtomic(path string, data []byte) error {
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+	tmp := path + ".taltun-tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -1,18 +1,44 @@
 package netutil
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"sync"
+	"syscall"
 
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 )
 
-// AssignIP asigna la dirección IP a una interfaz ya existente.
+// RouteProtocol marca las rutas instaladas por Taltun en el Kernel
+// (equivale a `ip route add ... proto 84`). Permite distinguir en el arranque
+// qué estado quedó huérfano tras un crash y qué pertenece al administrador.
+const RouteProtocol netlink.RouteProtocol = 84
+
+// Tracker configura la red del Kernel (IPs y rutas) y recuerda todo lo que
+// instala para poder deshacerlo en el cierre.
+// No es del hot-path: solo se usa durante el arranque y el apagado.
+type Tracker struct {
+	mu        sync.Mutex
+	ifaceName string
+
+	addrs  []netlink.Addr
+	routes []netlink.Route
+	rules  []netlink.Rule
+}
+
+// NewTracker crea un Tracker para la interfaz indicada.
+func NewTracker(ifaceName string) *Tracker {
+	return &Tracker{ifaceName: ifaceName}
+}
+
+// AssignIP asigna las direcciones (IPv4/IPv6, con su prefijo) a una interfaz ya existente.
 // (tun.CreateTUN ya creó la interfaz y seteó el MTU).
-func AssignIP(ifaceName string, ip net.IP) error {
-	link, err := netlink.LinkByName(ifaceName)
+func (t *Tracker) AssignIP(addrs []*net.IPNet) error {
+	link, err := netlink.LinkByName(t.ifaceName)
 	if err != nil {
-		return fmt.Errorf("no se encontró interfaz %s: %v", ifaceName, err)
+		return fmt.Errorf("no se encontró interfaz %s: %v", t.ifaceName, err)
 	}
 
 	// Asegurar que está UP (wireguard-go suele levantarla, pero por seguridad)
@@ -20,60 +46,185 @@ func AssignIP(ifaceName string, ip net.IP) error {
 		return fmt.Errorf("error levantando interfaz: %v", err)
 	}
 
-	ipNet := &net.IPNet{
-		IP:   ip,
-		Mask: net.CIDRMask(24, 32),
-	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
 
-	addr := &netlink.Addr{
-		IPNet: ipNet,
-		Label: "",
-	}
+	for _, ipNet := range addrs {
+		addr := netlink.Addr{
+			IPNet: ipNet,
+			Label: "",
+		}
 
-	if err := netlink.AddrAdd(link, addr); err != nil {
-		if !containsFileExists(err) {
-			return fmt.Errorf("error asignando IP %s: %v", ip, err)
+		// En IPv6 saltamos DAD: en un enlace punto a punto no hay con quién
+		// colisionar y evitamos ~1s con la dirección en estado "tentative".
+		if ipNet.IP.To4() == nil {
+			addr.Flags = unix.IFA_F_NODAD
 		}
+
+		if err := netlink.AddrAdd(link, &addr); err != nil {
+			if !isFileExists(err) {
+				return fmt.Errorf("error asignando IP %s: %v", ipNet, err)
+			}
+			// Ya estaba asignada por alguien más: no es nuestra, no la borramos al salir.
+			continue
+		}
+		t.addrs = append(t.addrs, addr)
 	}
 
 	return nil
 }
 
+// SetLinkMTU fija el MTU de una interfaz. Hace falta con TUN multi-queue: a
+// diferencia de tun.CreateTUN, abrir las colas a mano no lo configura.
+func SetLinkMTU(ifaceName string, mtu int) error {
+	link, err := netlink.LinkByName(ifaceName)
+	if err != nil {
+		return fmt.Errorf("no se encontró interfaz %s: %v", ifaceName, err)
+	}
+	if err := netlink.LinkSetMTU(link, mtu); err != nil {
+		return fmt.Errorf("error fijando MTU %d: %v", mtu, err)
+	}
+	return nil
+}
+
 // AddRoutes inyecta rutas estáticas en el Kernel apuntando a la interfaz.
-func AddRoutes(ifaceName string, routes []string) error {
+// Las rutas se marcan con RouteProtocol para poder reconocerlas después.
+// Devuelve las rutas que ya existían con otro dueño (p.ej. la ruta "proto kernel"
+// que crea AssignIP para el prefijo de la VIP): se respetan y no se tocan al salir.
+func (t *Tracker) AddRoutes(routes []string) (skipped []string, err error) {
 	if len(routes) == 0 {
-		return nil
+		return nil, nil
 	}
 
-	link, err := netlink.LinkByName(ifaceName)
+	link, err := netlink.LinkByName(t.ifaceName)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	
+
 	linkIdx := link.Attrs().Index
 
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
 	for _, cidr := range routes {
 		_, dst, err := net.ParseCIDR(cidr)
 		if err != nil {
-			return fmt.Errorf("CIDR invalido %s: %v", cidr, err)
+			return skipped, fmt.Errorf("CIDR invalido %s: %v", cidr, err)
 		}
 
-		route := &netlink.Route{
+		route := netlink.Route{
 			LinkIndex: linkIdx,
 			Dst:       dst,
+			Protocol:  RouteProtocol,
 		}
 
-		// ip route add <cidr> dev <ifaceName>
-		if err := netlink.RouteAdd(route); err != nil {
-			if !containsFileExists(err) {
-				return fmt.Errorf("error añadiendo ruta %s: %v", cidr, err)
+		// ip route add <cidr> dev <ifaceName> proto 84
+		if err := netlink.RouteAdd(&route); err != nil {
+			if !isFileExists(err) {
+				return skipped, fmt.Errorf("error añadiendo ruta %s: %v", cidr, err)
 			}
+			// Las huérfanas propias ya se limpiaron en CleanupStale, así que esta
+			// pertenece a otro. La dejamos tal cual.
+			skipped = append(skipped, cidr)
+			continue
 		}
+		t.routes = append(t.routes, route)
 	}
-	return nil
+	return skipped, nil
+}
+
+// Cleanup elimina, en orden inverso, todo lo que instaló el Tracker.
+// Es idempotente: una segunda llamada no hace nada.
+func (t *Tracker) Cleanup() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var errs []error
+
+	// Primero las reglas: así ningún paquete cae en una tabla a medio vaciar.
+	for i := len(t.rules) - 1; i >= 0; i-- {
+		if err := netlink.RuleDel(&t.rules[i]); err != nil && !isNotFound(err) {
+			errs = append(errs, fmt.Errorf("error borrando ip rule (prio %d): %v", t.rules[i].Priority, err))
+		}
+	}
+	t.rules = nil
+
+	for i := len(t.routes) - 1; i >= 0; i-- {
+		if err := netlink.RouteDel(&t.routes[i]); err != nil && !isNotFound(err) {
+			errs = append(errs, fmt.Errorf("error borrando ruta %s: %v", t.routes[i].Dst, err))
+		}
+	}
+	t.routes = nil
+
+	if len(t.addrs) > 0 {
+		// Si la interfaz ya desapareció, el Kernel se llevó sus direcciones con ella.
+		if link, err := netlink.LinkByName(t.ifaceName); err == nil {
+			for i := len(t.addrs) - 1; i >= 0; i-- {
+				if err := netlink.AddrDel(link, &t.addrs[i]); err != nil && !isNotFound(err) {
+					errs = append(errs, fmt.Errorf("error borrando IP %s: %v", t.addrs[i].IPNet, err))
+				}
+			}
+		}
+	}
+	t.addrs = nil
+
+	return errors.Join(errs...)
+}
+
+// CleanupStale busca rutas y reglas marcadas con RouteProtocol en todas las
+// tablas (restos de una ejecución anterior que no cerró limpio) y las elimina.
+// Solo toca las que apuntan a ifaceName, a una interfaz que ya no existe o a
+// nuestra tabla de Full Tunnel, para no pisar a otra instancia de Taltun
+// corriendo en el mismo host.
+// Devuelve cuántas entradas se borraron.
+func CleanupStale(ifaceName string, table int) (int, error) {
+	ownIdx := -1
+	if link, err := netlink.LinkByName(ifaceName); err == nil {
+		ownIdx = link.Attrs().Index
+	}
+
+	removed, err := cleanupStaleRules(table)
+	if err != nil {
+		return removed, err
+	}
+
+	filter := &netlink.Route{
+		Protocol: RouteProtocol,
+		Table:    unix.RT_TABLE_UNSPEC,
+	}
+	mask := netlink.RT_FILTER_PROTOCOL | netlink.RT_FILTER_TABLE
+
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		routes, err := netlink.RouteListFiltered(family, filter, mask)
+		if err != nil {
+			return removed, fmt.Errorf("error listando rutas: %v", err)
+		}
+		for i := range routes {
+			idx := routes[i].LinkIndex
+			switch {
+			case idx == 0:
+				// Sin interfaz (kill-switch unreachable): solo si es de nuestra tabla.
+				if routes[i].Table != table {
+					continue
+				}
+			case idx != ownIdx:
+				if _, err := netlink.LinkByIndex(idx); err == nil {
+					continue // Pertenece a otra instancia viva
+				}
+			}
+			if err := netlink.RouteDel(&routes[i]); err != nil && !isNotFound(err) {
+				return removed, fmt.Errorf("error borrando ruta huérfana %s: %v", routes[i].Dst, err)
+			}
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+func isFileExists(err error) bool {
+	return errors.Is(err, syscall.EEXIST)
 }
 
-func containsFileExists(err error) bool {
-	return err != nil && (err.Error() == "file exists" || 
-		(len(err.Error()) > 0 && err.Error()[len(err.Error())-11:] == "file exists"))
+func isNotFound(err error) bool {
+	return errors.Is(err, syscall.ESRCH) || errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ENODEV)
 }
@@ -0,0 +1,101 @@
+package netutil
+
+import (
+	"encoding/binary"
+	"math/bits"
+
+	"golang.org/x/sys/unix"
+)
+
+// --- TCP MSS CLAMPING ---
+
+const (
+	tcpFlagSYN   = 0x02
+	tcpOptEnd    = 0
+	tcpOptNOP    = 1
+	tcpOptMSS    = 2
+	tcpOptMSSLen = 4
+)
+
+// ClampMSS rebaja la opción MSS de un SYN o SYN-ACK TCP para que los segmentos
+// quepan en mtu (como iptables -j TCPMSS --clamp-mss-to-pmtu) y corrige el
+// checksum. Devuelve true si ha modificado el paquete. El resto de paquetes
+// (incluidos los fragmentos IPv4) no se tocan.
+func ClampMSS(pkt []byte, mtu int) bool {
+	if len(pkt) < 1 {
+		return false
+	}
+
+	var tcp []byte
+	var mss int
+	switch pkt[0] >> 4 {
+	case 4:
+		ihl := int(pkt[0]&0x0f) * 4
+		if len(pkt) < 20 || ihl < 20 || pkt[9] != unix.IPPROTO_TCP ||
+			binary.BigEndian.Uint16(pkt[6:8])&0x1fff != 0 {
+			return false
+		}
+		tcp = pkt[min(ihl, len(pkt)):]
+		mss = mtu - 20 - 20
+	case 6:
+		// Solo sin cabeceras de extensión (lo habitual en un SYN)
+		if len(pkt) < 40 || pkt[6] != unix.IPPROTO_TCP {
+			return false
+		}
+		tcp = pkt[40:]
+		mss = mtu - 40 - 20
+	default:
+		return false
+	}
+
+	if len(tcp) < 20 || tcp[13]&tcpFlagSYN == 0 || mss <= 0 {
+		return false
+	}
+	dataOff := int(tcp[12]>>4) * 4
+	if dataOff < 20 || dataOff > len(tcp) {
+		return false
+	}
+
+	opts := tcp[20:dataOff]
+	for len(opts) > 0 {
+		switch opts[0] {
+		case tcpOptEnd:
+			return false
+		case tcpOptNOP:
+			opts = opts[1:]
+			continue
+		}
+		if len(opts) < 2 || opts[1] < 2 || int(opts[1]) > len(opts) {
+			return false
+		}
+		if opts[0] == tcpOptMSS && opts[1] == tcpOptMSSLen {
+			old := binary.BigEndian.Uint16(opts[2:4])
+			if int(old) <= mss {
+				return false
+			}
+			binary.BigEndian.PutUint16(opts[2:4], uint16(mss))
+
+			// En posición impar el valor cae entre dos palabras del checksum:
+			// la suma en complemento a uno equivale a la de los bytes girados.
+			oldW, newW := old, uint16(mss)
+			if (dataOff-len(opts)+2)%2 != 0 {
+				oldW, newW = bits.ReverseBytes16(oldW), bits.ReverseBytes16(newW)
+			}
+			sum := binary.BigEndian.Uint16(tcp[16:18])
+			binary.BigEndian.PutUint16(tcp[16:18], checksumUpdate(sum, oldW, newW))
+			return true
+		}
+		opts = opts[opts[1]:]
+	}
+	return false
+}
+
+// checksumUpdate ajusta un checksum cuando una palabra de 16 bits cambia de
+// old a new sin recalcularlo entero (RFC 1624: HC' = ~(~HC + ~m + m')).
+func checksumUpdate(sum, old, new uint16) uint16 {
+	s := uint32(^sum) + uint32(^old) + uint32(new)
+	for s > 0xffff {
+		s = (s >> 16) + (s & 0xffff)
+	}
+	return ^uint16(s)
+}
@@ -0,0 +1,57 @@
+package netutil
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// tcpSYN arma un SYN IPv4 con las opciones dadas y su checksum TCP correcto.
+func tcpSYN(opts []byte) []byte {
+	tcpLen := 20 + len(opts)
+	pkt := ipv4Packet(20+tcpLen, true)
+	tcp := pkt[20:]
+	binary.BigEndian.PutUint16(tcp[0:2], 40000)
+	binary.BigEndian.PutUint16(tcp[2:4], 443)
+	tcp[12] = byte(tcpLen/4) << 4
+	tcp[13] = tcpFlagSYN
+	copy(tcp[20:], opts)
+	binary.BigEndian.PutUint16(tcp[16:18], checksum(tcp, tcpPseudoSum(pkt)))
+	return pkt
+}
+
+func tcpPseudoSum(pkt []byte) uint32 {
+	return sum16(pkt[12:20], uint32(len(pkt)-20)+6)
+}
+
+func TestClampMSS(t *testing.T) {
+	cases := []struct {
+		name string
+		opts []byte
+		at   int // Posición del valor MSS en el paquete
+	}{
+		{"aligned", []byte{2, 4, 0x05, 0xb4, 1, 1, 1, 0}, 42},
+		{"odd offset", []byte{1, 2, 4, 0x05, 0xb4, 1, 1, 0}, 43},
+	}
+	for _, c := range cases {
+		pkt := tcpSYN(c.opts)
+		if !ClampMSS(pkt, 1380) {
+			t.Fatalf("%s: MSS not clamped", c.name)
+		}
+		if mss := binary.BigEndian.Uint16(pkt[c.at:]); mss != 1340 {
+			t.Errorf("%s: MSS = %d, want 1340", c.name, mss)
+		}
+		if checksum(pkt[20:], tcpPseudoSum(pkt)) != 0 {
+			t.Errorf("%s: bad TCP checksum after clamping", c.name)
+		}
+	}
+
+	// Un MSS ya pequeño y los paquetes sin SYN se dejan como están
+	if ClampMSS(tcpSYN([]byte{2, 4, 0x04, 0x00}), 1380) {
+		t.Errorf("Small MSS modified")
+	}
+	pkt := tcpSYN([]byte{2, 4, 0x05, 0xb4})
+	pkt[33] = 0x10 // ACK
+	if ClampMSS(pkt, 1380) {
+		t.Errorf("Non-SYN packet modified")
+	}
+}
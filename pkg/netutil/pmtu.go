@@ -0,0 +1,173 @@
+package netutil
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// --- PATH MTU ---
+
+// SetDontFragment hace que el socket envíe siempre con DF y sin fragmentar
+// localmente, ignorando la caché de PMTU del Kernel (IP_PMTUDISC_PROBE): el
+// MTU del camino lo miden nuestras sondas, y un datagrama que no cabe se
+// pierde en vez de viajar troceado. Admite sockets IPv4 e IPv6.
+func SetDontFragment(conn *net.UDPConn) error {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var err4, err6 error
+	if err := rc.Control(func(fd uintptr) {
+		err4 = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, unix.IP_PMTUDISC_PROBE)
+		err6 = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_MTU_DISCOVER, unix.IPV6_PMTUDISC_PROBE)
+	}); err != nil {
+		return err
+	}
+	// Un socket IPv4 rechaza la opción IPv6 y viceversa: basta con una.
+	if err4 != nil && err6 != nil {
+		return errors.Join(err4, err6)
+	}
+	return nil
+}
+
+const (
+	icmpv4TypeDestUnreachable = 3
+	icmpv4CodeFragNeeded      = 4
+	icmpv6TypePacketTooBig    = 2
+
+	// Lo que se cita del paquete original: que el mensaje entero quepa en el
+	// mínimo garantizado (576 en IPv4, 1280 en IPv6).
+	icmpv4MaxQuote = 576 - 20 - 8
+	icmpv6MaxQuote = 1280 - 40 - 8
+)
+
+// PacketTooBig escribe en dst el error ICMP que devolvería un router para pkt,
+// que no cabe en mtu: "Fragmentation Needed" (IPv4) o "Packet Too Big"
+// (ICMPv6). El mensaje va del destino de pkt a su origen, para escribirlo en
+// la TUN como si viniera del otro lado del túnel. Devuelve su longitud, o 0 si
+// pkt no admite ese error (IPv4 sin DF, fragmentos no iniciales, errores ICMP)
+// o dst no tiene sitio.
+func PacketTooBig(dst, pkt []byte, mtu int) int {
+	if len(pkt) < 1 {
+		return 0
+	}
+	switch pkt[0] >> 4 {
+	case 4:
+		return packetTooBig4(dst, pkt, mtu)
+	case 6:
+		return packetTooBig6(dst, pkt, mtu)
+	}
+	return 0
+}
+
+func packetTooBig4(dst, pkt []byte, mtu int) int {
+	ihl := int(pkt[0]&0x0f) * 4
+	if len(pkt) < 20 || ihl < 20 || len(pkt) < ihl {
+		return 0
+	}
+	flags := binary.BigEndian.Uint16(pkt[6:8])
+	if flags&0x4000 == 0 || flags&0x1fff != 0 {
+		return 0 // Sin DF el origen no espera el error; los fragmentos los cita el primero
+	}
+	if pkt[9] == unix.IPPROTO_ICMP && len(pkt) > ihl && isICMPv4Error(pkt[ihl]) {
+		return 0
+	}
+
+	quote := min(len(pkt), icmpv4MaxQuote)
+	total := 20 + 8 + quote
+	if len(dst) < total {
+		return 0
+	}
+
+	ip := dst[:20]
+	ip[0] = 0x45
+	ip[1] = 0
+	binary.BigEndian.PutUint16(ip[2:4], uint16(total))
+	binary.BigEndian.PutUint32(ip[4:8], 0) // ID y flags
+	ip[8] = 64
+	ip[9] = unix.IPPROTO_ICMP
+	binary.BigEndian.PutUint16(ip[10:12], 0)
+	copy(ip[12:16], pkt[16:20])
+	copy(ip[16:20], pkt[12:16])
+	binary.BigEndian.PutUint16(ip[10:12], checksum(ip, 0))
+
+	icmp := dst[20:total]
+	icmp[0] = icmpv4TypeDestUnreachable
+	icmp[1] = icmpv4CodeFragNeeded
+	binary.BigEndian.PutUint16(icmp[2:4], 0)
+	binary.BigEndian.PutUint16(icmp[4:6], 0)
+	binary.BigEndian.PutUint16(icmp[6:8], uint16(mtu))
+	copy(icmp[8:], pkt[:quote])
+	binary.BigEndian.PutUint16(icmp[2:4], checksum(icmp, 0))
+	return total
+}
+
+func isICMPv4Error(t byte) bool {
+	switch t {
+	case 3, 4, 5, 11, 12: // Unreachable, Source Quench, Redirect, Time Exceeded, Param Problem
+		return true
+	}
+	return false
+}
+
+func packetTooBig6(dst, pkt []byte, mtu int) int {
+	if len(pkt) < 40 {
+		return 0
+	}
+	// Los errores ICMPv6 son los tipos < 128 (solo miramos la cabecera fija).
+	if pkt[6] == unix.IPPROTO_ICMPV6 && len(pkt) > 40 && pkt[40] < 128 {
+		return 0
+	}
+
+	quote := min(len(pkt), icmpv6MaxQuote)
+	payload := 8 + quote
+	total := 40 + payload
+	if len(dst) < total {
+		return 0
+	}
+
+	ip := dst[:40]
+	binary.BigEndian.PutUint32(ip[0:4], 6<<28)
+	binary.BigEndian.PutUint16(ip[4:6], uint16(payload))
+	ip[6] = unix.IPPROTO_ICMPV6
+	ip[7] = 64
+	copy(ip[8:24], pkt[24:40])
+	copy(ip[24:40], pkt[8:24])
+
+	icmp := dst[40:total]
+	icmp[0] = icmpv6TypePacketTooBig
+	icmp[1] = 0
+	binary.BigEndian.PutUint16(icmp[2:4], 0)
+	binary.BigEndian.PutUint32(icmp[4:8], uint32(mtu))
+	copy(icmp[8:], pkt[:quote])
+
+	// Pseudo-cabecera: origen, destino, longitud y siguiente cabecera.
+	sum := sum16(ip[8:40], 0)
+	sum += uint32(payload) + unix.IPPROTO_ICMPV6
+	binary.BigEndian.PutUint16(icmp[2:4], checksum(icmp, sum))
+	return total
+}
+
+// checksum es la suma de complemento a uno de b (RFC 1071), partiendo de la
+// suma parcial initial (p.ej. una pseudo-cabecera).
+func checksum(b []byte, initial uint32) uint16 {
+	sum := sum16(b, initial)
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
+
+func sum16(b []byte, sum uint32) uint32 {
+	for len(b) >= 2 {
+		sum += uint32(binary.BigEndian.Uint16(b))
+		b = b[2:]
+	}
+	if len(b) == 1 {
+		sum += uint32(b[0]) << 8
+	}
+	return sum
+}
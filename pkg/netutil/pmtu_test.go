@@ -0,0 +1,74 @@
+package netutil
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func ipv4Packet(size int, df bool) []byte {
+	pkt := make([]byte, size)
+	pkt[0] = 0x45
+	binary.BigEndian.PutUint16(pkt[2:4], uint16(size))
+	if df {
+		pkt[6] = 0x40
+	}
+	pkt[8] = 64
+	pkt[9] = 6 // TCP
+	copy(pkt[12:16], net.ParseIP("10.9.0.2").To4())
+	copy(pkt[16:20], net.ParseIP("10.9.0.1").To4())
+	binary.BigEndian.PutUint16(pkt[10:12], checksum(pkt[:20], 0))
+	return pkt
+}
+
+func TestPacketTooBigIPv4(t *testing.T) {
+	pkt := ipv4Packet(1500, true)
+	dst := make([]byte, 2048)
+
+	n := PacketTooBig(dst, pkt, 1400)
+	if n != 576 {
+		t.Fatalf("Expected a 576 byte ICMP, got %d", n)
+	}
+	msg := dst[:n]
+	if checksum(msg[:20], 0) != 0 || checksum(msg[20:], 0) != 0 {
+		t.Errorf("Bad checksum")
+	}
+	if !net.IP(msg[12:16]).Equal(net.ParseIP("10.9.0.1")) || !net.IP(msg[16:20]).Equal(net.ParseIP("10.9.0.2")) {
+		t.Errorf("Addresses not swapped: %v -> %v", net.IP(msg[12:16]), net.IP(msg[16:20]))
+	}
+	if msg[20] != 3 || msg[21] != 4 || binary.BigEndian.Uint16(msg[26:28]) != 1400 {
+		t.Errorf("Wrong ICMP header: % x", msg[20:28])
+	}
+
+	if PacketTooBig(dst, ipv4Packet(1500, false), 1400) != 0 {
+		t.Errorf("ICMP generated for a packet without DF")
+	}
+}
+
+func TestPacketTooBigIPv6(t *testing.T) {
+	pkt := make([]byte, 1500)
+	binary.BigEndian.PutUint32(pkt[0:4], 6<<28)
+	binary.BigEndian.PutUint16(pkt[4:6], 1500-40)
+	pkt[6] = 17 // UDP
+	copy(pkt[8:24], net.ParseIP("fd00::2"))
+	copy(pkt[24:40], net.ParseIP("fd00::1"))
+	dst := make([]byte, 2048)
+
+	n := PacketTooBig(dst, pkt, 1400)
+	if n != 1280 {
+		t.Fatalf("Expected a 1280 byte ICMPv6, got %d", n)
+	}
+	msg := dst[:n]
+	sum := sum16(msg[8:40], uint32(n-40)+58)
+	if checksum(msg[40:], sum) != 0 {
+		t.Errorf("Bad ICMPv6 checksum")
+	}
+	if msg[40] != 2 || binary.BigEndian.Uint32(msg[44:48]) != 1400 {
+		t.Errorf("Wrong ICMPv6 header: % x", msg[40:48])
+	}
+
+	// Nunca un error en respuesta a otro error ICMPv6
+	if PacketTooBig(dst, msg, 1280) != 0 {
+		t.Errorf("ICMPv6 error generated for an ICMPv6 error")
+	}
+}
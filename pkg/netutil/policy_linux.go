@@ -0,0 +1,172 @@
+package netutil
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"os"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// Valores por defecto del modo Full Tunnel (0x5474 = "Tt").
+const (
+	DefaultFwMark = 0x5474
+	DefaultTable  = 0x5474
+)
+
+// Prioridades de nuestras reglas `ip rule`. Deben evaluarse antes que la regla
+// "lookup main" (32766) y el orden entre ellas importa (ver EnableFullTunnel).
+const (
+	rulePrioSuppress = 5200
+	rulePrioTunnel   = 5201
+)
+
+// Policy describe el enrutamiento por políticas del modo Full Tunnel.
+type Policy struct {
+	FwMark     uint32 // Marca que llevan los sockets UDP del engine
+	Table      int    // Tabla de rutas dedicada al túnel
+	KillSwitch bool   // Bloquear el tráfico si la TUN desaparece
+}
+
+// IsDefaultRoute indica si el CIDR es una ruta por defecto (0.0.0.0/0 o ::/0).
+func IsDefaultRoute(cidr string) bool {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false
+	}
+	ones, _ := ipNet.Mask.Size()
+	return ones == 0
+}
+
+// EnableFullTunnel instala las rutas por defecto en una tabla propia y las
+// reglas que la hacen efectiva (mismo esquema que wg-quick):
+//
+//	ip rule add table main suppress_prefixlength 0   (prio 5200)
+//	ip rule add not fwmark <mark> table <table>      (prio 5201)
+//
+// La primera deja pasar las rutas específicas de main (LAN, enlaces locales) pero
+// ignora su default. La segunda manda todo lo que no lleve la marca al túnel,
+// de modo que el tráfico cifrado del engine (marcado con SO_MARK) sale por la
+// ruta original y no entra en bucle.
+//
+// Con KillSwitch se añade además un `unreachable default` de métrica máxima en la
+// tabla: si la TUN cae, su ruta desaparece y el tráfico se bloquea en vez de
+// escapar por la interfaz física.
+func (t *Tracker) EnableFullTunnel(defaults []string, p Policy) error {
+	link, err := netlink.LinkByName(t.ifaceName)
+	if err != nil {
+		return fmt.Errorf("no se encontró interfaz %s: %v", t.ifaceName, err)
+	}
+	linkIdx := link.Attrs().Index
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	families := make(map[int]bool)
+
+	for _, cidr := range defaults {
+		_, dst, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("CIDR invalido %s: %v", cidr, err)
+		}
+		family := netlink.FAMILY_V4
+		if dst.IP.To4() == nil {
+			family = netlink.FAMILY_V6
+		}
+		families[family] = true
+
+		route := netlink.Route{
+			LinkIndex: linkIdx,
+			Dst:       dst,
+			Table:     p.Table,
+			Protocol:  RouteProtocol,
+		}
+		if err := netlink.RouteAdd(&route); err != nil {
+			return fmt.Errorf("error añadiendo ruta %s (tabla %d): %v", cidr, p.Table, err)
+		}
+		t.routes = append(t.routes, route)
+
+		if p.KillSwitch {
+			block := netlink.Route{
+				Dst:      dst,
+				Table:    p.Table,
+				Type:     unix.RTN_UNREACHABLE,
+				Priority: math.MaxUint32,
+				Protocol: RouteProtocol,
+			}
+			if err := netlink.RouteAdd(&block); err != nil {
+				return fmt.Errorf("error añadiendo kill-switch %s: %v", cidr, err)
+			}
+			t.routes = append(t.routes, block)
+		}
+	}
+
+	for family := range families {
+		suppress := netlink.NewRule()
+		suppress.Family = family
+		suppress.Table = unix.RT_TABLE_MAIN
+		suppress.SuppressPrefixlen = 0
+		suppress.Priority = rulePrioSuppress
+		suppress.Protocol = uint8(RouteProtocol)
+
+		tunnel := netlink.NewRule()
+		tunnel.Family = family
+		tunnel.Table = p.Table
+		tunnel.Mark = p.FwMark
+		tunnel.Invert = true
+		tunnel.Priority = rulePrioTunnel
+		tunnel.Protocol = uint8(RouteProtocol)
+
+		for _, r := range []*netlink.Rule{suppress, tunnel} {
+			// Una regla que ya existía no es nuestra: Cleanup no debe borrarla.
+			if err := netlink.RuleAdd(r); err != nil {
+				if isFileExists(err) {
+					continue
+				}
+				return fmt.Errorf("error añadiendo ip rule (prio %d): %v", r.Priority, err)
+			}
+			t.rules = append(t.rules, *r)
+		}
+	}
+
+	// Sin esto, rp_filter descarta las respuestas que llegan por la interfaz
+	// física a sockets marcados (lo mismo que hace wg-quick).
+	if families[netlink.FAMILY_V4] {
+		if err := os.WriteFile("/proc/sys/net/ipv4/conf/all/src_valid_mark", []byte("1"), 0644); err != nil {
+			return fmt.Errorf("error activando src_valid_mark: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// cleanupStaleRules elimina las reglas marcadas con RouteProtocol que apuntan
+// a nuestra tabla, más la regla suppress_prefixlength que las acompaña.
+func cleanupStaleRules(table int) (int, error) {
+	removed := 0
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		rules, err := netlink.RuleList(family)
+		if err != nil {
+			return removed, fmt.Errorf("error listando reglas: %v", err)
+		}
+		for i := range rules {
+			r := &rules[i]
+			if r.Protocol != uint8(RouteProtocol) {
+				continue
+			}
+			ours := r.Table == table ||
+				(r.Table == unix.RT_TABLE_MAIN && r.SuppressPrefixlen == 0 && r.Priority == rulePrioSuppress)
+			if !ours {
+				continue
+			}
+			r.Family = family
+			if err := netlink.RuleDel(r); err != nil && !isNotFound(err) {
+				return removed, fmt.Errorf("error borrando regla huérfana (prio %d): %v", r.Priority, err)
+			}
+			removed++
+		}
+	}
+	return removed, nil
+}
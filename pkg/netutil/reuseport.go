@@ -10,7 +10,9 @@ import (
 
 // ListenUDPReusePort crea un UDPConn con las flags SO_REUSEPORT y SO_REUSEADDR activadas.
 // Esto permite lanzar múltiples listeners en el mismo puerto y que el Kernel distribuya la carga.
-func ListenUDPReusePort(network, address string) (*net.UDPConn, error) {
+// Si fwmark != 0 se aplica SO_MARK, para que el policy routing del modo Full Tunnel
+// saque nuestro tráfico cifrado por la ruta física y no por la TUN.
+func ListenUDPReusePort(network, address string, fwmark uint32) (*net.UDPConn, error) {
 	lc := net.ListenConfig{
 		Control: func(network, address string, c syscall.RawConn) error {
 			var opErr error
@@ -22,6 +24,10 @@ func ListenUDPReusePort(network, address string) (*net.UDPConn, error) {
 				}
 				// Activar SO_REUSEADDR (buena práctica para reiniciar rápido)
 				opErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1)
+				if opErr != nil || fwmark == 0 {
+					return
+				}
+				opErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_MARK, int(fwmark))
 			})
 			if err != nil {
 				return err
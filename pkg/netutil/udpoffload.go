@@ -0,0 +1,74 @@
+package netutil
+
+import (
+	"encoding/binary"
+	"net"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// --- UDP GSO / GRO (Linux >= 4.18 / 5.0) ---
+//
+// GSO (UDP_SEGMENT): un solo sendmsg con un super-buffer que el Kernel (o la
+// NIC) trocea en datagramas de gso_size bytes hacia el mismo destino.
+// GRO (UDP_GRO): el Kernel entrega de una vez varios datagramas consecutivos
+// del mismo flujo, concatenados, e indica su tamaño en un cmsg.
+
+const (
+	// UDPMaxSegments es el máximo de segmentos por envío GSO (UDP_MAX_SEGMENTS).
+	UDPMaxSegments = 64
+	// UDPMaxGSOSize es el tamaño máximo de un super-buffer (payload UDP en IPv4).
+	UDPMaxGSOSize = 65507
+	// GROBufferSize es lo que puede entregar una lectura con GRO activo.
+	GROBufferSize = 65535
+)
+
+// EnableUDPOffload comprueba si el socket admite GSO y activa GRO. Devuelve
+// lo que quedó disponible: sin soporte, el llamante sigue datagrama a datagrama.
+func EnableUDPOffload(conn *net.UDPConn) (gso, gro bool) {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return false, false
+	}
+	rc.Control(func(fd uintptr) {
+		_, err := unix.GetsockoptInt(int(fd), unix.IPPROTO_UDP, unix.UDP_SEGMENT)
+		gso = err == nil
+		gro = unix.SetsockoptInt(int(fd), unix.IPPROTO_UDP, unix.UDP_GRO, 1) == nil
+	})
+	return gso, gro
+}
+
+// GSOControlSize es el espacio que ocupa el cmsg de PutGSOSize.
+var GSOControlSize = unix.CmsgSpace(2)
+
+// GROControlSize es el espacio necesario para recibir el cmsg de UDP_GRO.
+var GROControlSize = unix.CmsgSpace(4)
+
+// PutGSOSize escribe en oob el cmsg UDP_SEGMENT con el tamaño de segmento y
+// devuelve la parte usada. oob debe tener al menos GSOControlSize bytes.
+func PutGSOSize(oob []byte, segSize uint16) []byte {
+	oob = oob[:GSOControlSize]
+	clear(oob)
+	hdr := (*unix.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	hdr.Level = unix.IPPROTO_UDP
+	hdr.Type = unix.UDP_SEGMENT
+	hdr.SetLen(unix.CmsgLen(2))
+	binary.NativeEndian.PutUint16(oob[unix.CmsgLen(0):], segSize)
+	return oob
+}
+
+// GROSize extrae el tamaño de segmento del cmsg UDP_GRO (0 si el mensaje
+// recibido es un datagrama normal).
+func GROSize(oob []byte) int {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0
+	}
+	for _, m := range msgs {
+		if m.Header.Level == unix.IPPROTO_UDP && m.Header.Type == unix.UDP_GRO && len(m.Data) >= 4 {
+			return int(binary.NativeEndian.Uint32(m.Data))
+		}
+	}
+	return 0
+}
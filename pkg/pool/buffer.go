@@ -1,37 +1,133 @@
 package pool
 
 import (
+	"fmt"
 	"sync"
 )
 
-// BufferSize define el tamaño fijo de nuestros paquetes en memoria.
-// 2048 bytes cubre holgadamente un MTU de 1500 bytes + Headers VPN.
+// Class es una clase de tamaño del pool. Cada clase tiene su propio sync.Pool
+// y todos sus buffers miden exactamente lo mismo.
+type Class uint8
+
+const (
+	ClassSmall   Class = iota // 256 B: keepalives y mensajes de control cortos
+	ClassDefault              // 2 KB: MTU estándar (1500) + cabeceras VPN
+	ClassJumbo                // 16 KB: MTU jumbo (9000) + cabeceras VPN
+	ClassHuge                 // 64 KB: super-buffers GSO/GRO
+
+	numClasses
+)
+
+var classSizes = [numClasses]int{256, 2048, 16 << 10, 64 << 10}
+
+// BufferSize es el tamaño de ClassDefault, la clase que usa Get mientras no
+// se llame a SetDefaultSize.
 const BufferSize = 2048
 
-// Buff es un alias para el array fijo.
-// Usamos un array en lugar de un slice para garantizar localidad de memoria
-// y evitar la sobrecarga de la estructura header del slice en el pool.
-type Buff [BufferSize]byte
+// MaxSize es el mayor buffer que puede servir el pool.
+const MaxSize = 64 << 10
+
+// Buff es un buffer del pool. B tiene longitud y capacidad iguales al tamaño
+// de su clase; el array se reserva una sola vez y se recicla con Put, así que
+// Get/Put no alocan en régimen estable.
+type Buff struct {
+	B     []byte
+	class Class
+}
+
+// Class devuelve la clase del buffer.
+func (b *Buff) Class() Class {
+	return b.class
+}
+
+var pools [numClasses]sync.Pool
+
+// defaultClass es la clase de Get. Solo se cambia en el arranque (antes de
+// lanzar las goroutines que usan el pool).
+var defaultClass = ClassDefault
+
+func init() {
+	for c := range pools {
+		class := Class(c)
+		pools[c].New = func() interface{} {
+			// Alocamos el array junto al descriptor y devolvemos el puntero:
+			// al reciclarlo no se copian los bytes ni se vuelve a reservar.
+			return &Buff{B: make([]byte, classSizes[class]), class: class}
+		}
+	}
+}
 
-var bPool = sync.Pool{
-	New: func() interface{} {
-		// Alocamos el array y devolvemos su puntero.
-		// Al devolver un puntero, evitamos copiar los 2KB al sacarlo del pool.
-		return new(Buff)
-	},
+// Size devuelve el tamaño de los buffers de una clase.
+func (c Class) Size() int {
+	return classSizes[c]
 }
 
-// Get obtiene un buffer limpio del pool.
-// El buffer devuelto tiene longitud 2048 y capacidad 2048.
+func (c Class) String() string {
+	size := classSizes[c]
+	if size >= 1024 {
+		return fmt.Sprintf("%dK", size/1024)
+	}
+	return fmt.Sprintf("%dB", size)
+}
+
+// ClassFor devuelve la clase más pequeña con al menos size bytes.
+func ClassFor(size int) (Class, bool) {
+	for c := Class(0); c < numClasses; c++ {
+		if classSizes[c] >= size {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+// SetDefaultSize elige la clase de Get: la más pequeña que admite size bytes
+// (p.ej. MTU + cabeceras). Debe llamarse antes de usar el pool.
+func SetDefaultSize(size int) error {
+	c, ok := ClassFor(size)
+	if !ok {
+		return fmt.Errorf("pool: no hay buffers de %d bytes (máximo %d)", size, MaxSize)
+	}
+	defaultClass = c
+	return nil
+}
+
+// Size devuelve el tamaño de los buffers que entrega Get.
+func Size() int {
+	return classSizes[defaultClass]
+}
+
+// Get obtiene un buffer de la clase por defecto.
 // O(1) en la mayoría de casos.
 func Get() *Buff {
-	return bPool.Get().(*Buff)
+	return GetClass(defaultClass)
+}
+
+// GetSize obtiene un buffer de la clase más pequeña con al menos n bytes
+// (n no puede superar MaxSize).
+func GetSize(n int) *Buff {
+	c, ok := ClassFor(n)
+	if !ok {
+		panic(fmt.Sprintf("pool: GetSize(%d) supera MaxSize", n))
+	}
+	return GetClass(c)
+}
+
+// GetClass obtiene un buffer de la clase indicada.
+func GetClass(c Class) *Buff {
+	b := pools[c].Get().(*Buff)
+	if debugEnabled.Load() {
+		track(b)
+	}
+	return b
 }
 
-// Put devuelve un buffer al pool para ser reutilizado.
+// Put devuelve un buffer al pool de su clase para ser reutilizado.
 // IMPORTANTE: No es necesario limpiar (zeroing) el buffer aquí si el
 // consumidor (Reader) siempre sobrescribe o usa el length correcto,
 // lo cual ahorra ciclos de CPU valiosos (memset es caro).
 func Put(b *Buff) {
-	bPool.Put(b)
+	if debugEnabled.Load() && !untrack(b) {
+		return // Doble Put: reciclarlo otra vez lo entregaría a dos dueños
+	}
+	pools[b.class].Put(b)
 }
@@ -0,0 +1,90 @@
+package pool
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClassFor(t *testing.T) {
+	cases := []struct {
+		size int
+		want Class
+		ok   bool
+	}{
+		{1, ClassSmall, true},
+		{256, ClassSmall, true},
+		{257, ClassDefault, true},
+		{1420 + 17 + 16, ClassDefault, true},
+		{9000 + 17 + 16, ClassJumbo, true},
+		{65535, ClassHuge, true},
+		{MaxSize + 1, 0, false},
+	}
+	for _, c := range cases {
+		got, ok := ClassFor(c.size)
+		if ok != c.ok || (ok && got != c.want) {
+			t.Errorf("ClassFor(%d) = %v, %v; want %v, %v", c.size, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestGetPutClasses(t *testing.T) {
+	defer SetDefaultSize(BufferSize)
+
+	if b := Get(); len(b.B) != BufferSize || b.Class() != ClassDefault {
+		t.Fatalf("Default buffer: len %d class %v", len(b.B), b.Class())
+	}
+
+	if err := SetDefaultSize(9000 + 64); err != nil {
+		t.Fatal(err)
+	}
+	b := Get()
+	if len(b.B) != ClassJumbo.Size() || cap(b.B) != len(b.B) {
+		t.Fatalf("Jumbo buffer: len %d cap %d", len(b.B), cap(b.B))
+	}
+	Put(b)
+
+	small := GetSize(40)
+	if small.Class() != ClassSmall || len(small.B) != 256 {
+		t.Fatalf("Small buffer: len %d class %v", len(small.B), small.Class())
+	}
+	Put(small)
+
+	if err := SetDefaultSize(MaxSize + 1); err == nil {
+		t.Fatal("Expected error for a size above MaxSize")
+	}
+}
+
+func TestDebugTracking(t *testing.T) {
+	SetDebug(true)
+	defer SetDebug(false)
+	base := GetStats().DoublePuts
+
+	a := GetClass(ClassJumbo)
+	b := Get()
+	if s := GetStats(); s.Outstanding[ClassJumbo] != 1 || s.Outstanding[ClassDefault] != 1 {
+		t.Fatalf("Outstanding = %v", s.Outstanding)
+	}
+
+	leaks := Leaks(0)
+	if len(leaks) != 2 {
+		t.Fatalf("Expected 2 leaks, got %d", len(leaks))
+	}
+	if !strings.Contains(leaks[0].Stack, "TestDebugTracking") || strings.Contains(leaks[0].Stack, "pool.GetClass") {
+		t.Errorf("Unexpected stack: %q", leaks[0].Stack)
+	}
+	if len(Leaks(time.Hour)) != 0 {
+		t.Error("Fresh buffers reported as leaks")
+	}
+
+	Put(a)
+	Put(b)
+	Put(b) // Doble Put: se cuenta y no vuelve al pool
+	s := GetStats()
+	if s.Outstanding != [numClasses]int{} {
+		t.Errorf("Outstanding after Put = %v", s.Outstanding)
+	}
+	if s.DoublePuts != base+1 {
+		t.Errorf("DoublePuts = %d, want %d", s.DoublePuts, base+1)
+	}
+}
@@ -0,0 +1,139 @@
+package pool
+
+import (
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// --- MODO DEBUG: DETECCIÓN DE FUGAS ---
+//
+// Con SetDebug(true) cada Get apunta el buffer (y quién lo pidió) hasta su Put.
+// Así se puede ver cuántos buffers hay fuera por clase, cuáles llevan demasiado
+// tiempo sin volver (fugas) y detectar dobles Put. Cuesta un mutex y una
+// captura de pila por paquete: solo para diagnóstico, nunca en producción.
+
+const leakStackDepth = 8
+
+type trace struct {
+	since time.Time
+	pcs   [leakStackDepth]uintptr
+	n     int
+}
+
+var (
+	debugEnabled atomic.Bool
+
+	trackerMu   sync.Mutex
+	outstanding = make(map[*Buff]trace)
+	doublePuts  atomic.Uint64
+)
+
+// Stats resume el uso del pool en modo debug.
+type Stats struct {
+	Outstanding [numClasses]int // Buffers fuera del pool, por clase
+	DoublePuts  uint64          // Put de un buffer que ya estaba en el pool
+}
+
+// Leak es un buffer que lleva fuera del pool más de lo esperado.
+type Leak struct {
+	Class Class
+	Age   time.Duration
+	Stack string // Quién hizo el Get
+}
+
+// SetDebug activa o desactiva el seguimiento de buffers. Al activarlo solo se
+// siguen los Get posteriores (los buffers que ya estaban fuera no cuentan).
+func SetDebug(on bool) {
+	trackerMu.Lock()
+	defer trackerMu.Unlock()
+	debugEnabled.Store(on)
+	clear(outstanding)
+}
+
+// DebugEnabled indica si el seguimiento está activo.
+func DebugEnabled() bool {
+	return debugEnabled.Load()
+}
+
+func track(b *Buff) {
+	var t trace
+	t.since = time.Now()
+	t.n = runtime.Callers(2, t.pcs[:]) // Desde GetClass: formatStack omite el pool
+
+	trackerMu.Lock()
+	outstanding[b] = t
+	trackerMu.Unlock()
+}
+
+// untrack devuelve false si el buffer no constaba como prestado.
+func untrack(b *Buff) bool {
+	trackerMu.Lock()
+	_, ok := outstanding[b]
+	delete(outstanding, b)
+	trackerMu.Unlock()
+
+	if !ok {
+		doublePuts.Add(1)
+	}
+	return ok
+}
+
+// GetStats devuelve los contadores del modo debug (ceros si está desactivado).
+func GetStats() Stats {
+	var s Stats
+	trackerMu.Lock()
+	for b := range outstanding {
+		s.Outstanding[b.class]++
+	}
+	trackerMu.Unlock()
+	s.DoublePuts = doublePuts.Load()
+	return s
+}
+
+// Leaks devuelve los buffers que llevan fuera más de olderThan, del más
+// antiguo al más reciente.
+func Leaks(olderThan time.Duration) []Leak {
+	now := time.Now()
+	var leaks []Leak
+
+	trackerMu.Lock()
+	for b, t := range outstanding {
+		if age := now.Sub(t.since); age > olderThan {
+			leaks = append(leaks, Leak{Class: b.class, Age: age, Stack: formatStack(t.pcs[:t.n])})
+		}
+	}
+	trackerMu.Unlock()
+
+	sort.Slice(leaks, func(i, j int) bool { return leaks[i].Age > leaks[j].Age })
+	return leaks
+}
+
+// getFuncs son los marcos del propio pool en la pila de un Get.
+var getFuncs = func() map[string]bool {
+	pkg := reflect.TypeOf(Buff{}).PkgPath() + "."
+	return map[string]bool{pkg + "Get": true, pkg + "GetSize": true, pkg + "GetClass": true}
+}()
+
+// formatStack resume la pila de un Get sin los marcos del pool.
+func formatStack(pcs []uintptr) string {
+	var sb strings.Builder
+	frames := runtime.CallersFrames(pcs)
+	for {
+		f, more := frames.Next()
+		if !getFuncs[f.Function] {
+			if sb.Len() > 0 {
+				sb.WriteString(" <- ")
+			}
+			sb.WriteString(f.Function)
+		}
+		if !more {
+			break
+		}
+	}
+	return sb.String()
+}
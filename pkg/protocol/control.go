@@ -0,0 +1,143 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+// Los mensajes de control viajan cifrados igual que los de datos
+// (cabecera MsgTypeControl + AEAD), con la cabecera como dato adicional del
+// AEAD para que el tipo no se pueda cambiar en tránsito. El plaintext es una
+// lista de TLVs:
+//
+//	Tipo (1) + Longitud (1) + Valor (Longitud)
+//
+// Los tipos desconocidos se ignoran para poder ampliar el formato sin romper
+// a los nodos antiguos. Un tipo ControlPadding termina la lista: lo que sigue
+// es relleno (las sondas de PMTU lo usan para alcanzar el tamaño a medir).
+const (
+	ControlPadding      uint8 = 0x00 // Fin de la lista; el resto son ceros
+	ControlDNSServer    uint8 = 0x01 // IPv4 (4 bytes) o IPv6 (16 bytes)
+	ControlSearchDomain uint8 = 0x02 // Nombre de dominio (ASCII)
+	ControlPMTUProbe    uint8 = 0x03 // Sonda de PMTU: id (4 bytes), seguida de relleno
+	ControlPMTUAck      uint8 = 0x04 // Respuesta a una sonda: id (4) + bytes recibidos (2)
+)
+
+// PMTUProbeMinSize es el menor mensaje de sonda (el TLV sin relleno).
+const PMTUProbeMinSize = 2 + 4
+
+var ErrMalformedControl = errors.New("malformed control message")
+
+// EncodeDNSConfig serializa los servidores DNS y dominios de búsqueda que un
+// servidor anuncia a sus clientes.
+func EncodeDNSConfig(dst []byte, servers []net.IP, domains []string) (int, error) {
+	n := 0
+	put := func(t uint8, v []byte) error {
+		if len(v) > 255 {
+			return errors.New("control value too long")
+		}
+		if len(dst) < n+2+len(v) {
+			return errors.New("buffer too small for control message")
+		}
+		dst[n] = t
+		dst[n+1] = uint8(len(v))
+		copy(dst[n+2:], v)
+		n += 2 + len(v)
+		return nil
+	}
+
+	for _, ip := range servers {
+		v := []byte(ip.To4())
+		if v == nil {
+			v = ip.To16()
+		}
+		if v == nil {
+			return 0, errors.New("invalid dns server")
+		}
+		if err := put(ControlDNSServer, v); err != nil {
+			return 0, err
+		}
+	}
+	for _, d := range domains {
+		if err := put(ControlSearchDomain, []byte(d)); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// ParseDNSConfig decodifica un mensaje de control con configuración DNS.
+func ParseDNSConfig(src []byte) (servers []net.IP, domains []string, err error) {
+	for len(src) > 0 && src[0] != ControlPadding {
+		if len(src) < 2 {
+			return nil, nil, ErrMalformedControl
+		}
+		t, l := src[0], int(src[1])
+		if len(src) < 2+l {
+			return nil, nil, ErrMalformedControl
+		}
+		v := src[2 : 2+l]
+
+		switch t {
+		case ControlDNSServer:
+			if l != net.IPv4len && l != net.IPv6len {
+				return nil, nil, ErrMalformedControl
+			}
+			ip := make(net.IP, l)
+			copy(ip, v)
+			servers = append(servers, ip)
+		case ControlSearchDomain:
+			domains = append(domains, string(v))
+		}
+		src = src[2+l:]
+	}
+	return servers, domains, nil
+}
+
+// EncodePMTUProbe escribe en dst una sonda de PMTU de exactamente size bytes
+// (el TLV de la sonda seguido de relleno).
+func EncodePMTUProbe(dst []byte, id uint32, size int) (int, error) {
+	if size < PMTUProbeMinSize || len(dst) < size {
+		return 0, errors.New("buffer too small for pmtu probe")
+	}
+	dst[0] = ControlPMTUProbe
+	dst[1] = 4
+	binary.BigEndian.PutUint32(dst[2:6], id)
+	clear(dst[PMTUProbeMinSize:size])
+	return size, nil
+}
+
+// EncodePMTUAck escribe la respuesta a la sonda id, que llegó con size bytes.
+func EncodePMTUAck(dst []byte, id uint32, size int) (int, error) {
+	if len(dst) < 2+6 {
+		return 0, errors.New("buffer too small for control message")
+	}
+	dst[0] = ControlPMTUAck
+	dst[1] = 6
+	binary.BigEndian.PutUint32(dst[2:6], id)
+	binary.BigEndian.PutUint16(dst[6:8], uint16(min(size, 0xFFFF)))
+	return 8, nil
+}
+
+// ParsePMTU busca una sonda o una respuesta de PMTU en un mensaje de control.
+// Devuelve su tipo (ControlPMTUProbe o ControlPMTUAck), el id y, en las
+// respuestas, los bytes que recibió el peer. ok es false si no hay ninguna.
+func ParsePMTU(src []byte) (t uint8, id uint32, size int, ok bool) {
+	for len(src) >= 2 && src[0] != ControlPadding {
+		l := int(src[1])
+		if len(src) < 2+l {
+			return 0, 0, 0, false
+		}
+		v := src[2 : 2+l]
+
+		switch {
+		case src[0] == ControlPMTUProbe && l >= 4:
+			return ControlPMTUProbe, binary.BigEndian.Uint32(v), 0, true
+		case src[0] == ControlPMTUAck && l >= 6:
+			return ControlPMTUAck, binary.BigEndian.Uint32(v), int(binary.BigEndian.Uint16(v[4:6])), true
+		}
+		src = src[2+l:]
+	}
+	return 0, 0, 0, false
+}
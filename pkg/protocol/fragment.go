@@ -0,0 +1,44 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Un mensaje Data cifrado (cabecera + ciphertext + tag) que no cabe en el
+// camino se trocea en mensajes MsgTypeFragment. La cabecera va en claro: el
+// mensaje reensamblado se autentica al descifrarlo como cualquier otro.
+//
+//	Type (1) + SenderVIP (4) + ID (4) + Index (1) + Count (1) + Trozo
+const (
+	FragmentHeaderSize = 11
+	MaxFragments       = 16 // Trozos por mensaje
+)
+
+var ErrMalformedFragment = errors.New("malformed fragment")
+
+// EncodeFragmentHeader escribe la cabecera del trozo index (de count) del
+// mensaje id.
+func EncodeFragmentHeader(dst []byte, senderVIP, id uint32, index, count uint8) (int, error) {
+	if len(dst) < FragmentHeaderSize {
+		return 0, ErrBufferTooSmall
+	}
+	dst[0] = MsgTypeFragment
+	binary.BigEndian.PutUint32(dst[1:5], senderVIP)
+	binary.BigEndian.PutUint32(dst[5:9], id)
+	dst[9] = index
+	dst[10] = count
+	return FragmentHeaderSize, nil
+}
+
+// ParseFragment lee un mensaje MsgTypeFragment sin alocar memoria.
+func ParseFragment(src []byte) (senderVIP, id uint32, index, count uint8, data []byte, err error) {
+	if len(src) <= FragmentHeaderSize || src[0] != MsgTypeFragment {
+		return 0, 0, 0, 0, nil, ErrMalformedFragment
+	}
+	index, count = src[9], src[10]
+	if count < 2 || count > MaxFragments || index >= count {
+		return 0, 0, 0, 0, nil, ErrMalformedFragment
+	}
+	return binary.BigEndian.Uint32(src[1:5]), binary.BigEndian.Uint32(src[5:9]), index, count, src[FragmentHeaderSize:], nil
+}
@@ -3,13 +3,102 @@ package protocol
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"time"
 )
 
 const (
 	HandshakeBaseSize = 37 // 1 Type + 4 SenderIndex + 32 PubKey
 	CookieSize        = 16 // HMAC-MD5 o Blake2s truncado (suficiente para DoS protection)
+
+	// Con extensión el hueco de la cookie va siempre presente (a ceros si no hay
+	// cookie): así los nodos antiguos, que leen la cookie en [37:53] e ignoran
+	// el resto, siguen entendiendo el mensaje.
+	handshakeExtOffset = HandshakeBaseSize + CookieSize
+	handshakeExtHeader = 35 // 1 Version + 1 MinVersion + 2 Features + 1 Cipher + 16 Random + 12 Timestamp + 2 KEMLen
+
+	RandomSize    = 16
+	TimestampSize = 12 // TAI64N
+
+	// Segundos del epoch Unix en TAI64 (2^62 + 10 segundos intercalares)
+	tai64Base = 0x400000000000000a
 )
 
+// Versiones del protocolo. Un handshake sin extensión es de un nodo legacy (v1).
+const (
+	ProtocolVersionLegacy uint8 = 1 // Handshake clásico sin extensión (hasta v0.10)
+	ProtocolVersion2      uint8 = 2 // Extensión de handshake con features
+
+	CurrentProtocolVersion = ProtocolVersion2
+	MinProtocolVersion     = ProtocolVersionLegacy
+)
+
+// Features opcionales anunciadas en la extensión del handshake. Se usa la
+// intersección de las de ambos lados.
+const (
+	FeatureHybridKEM uint16 = 1 << 0 // ML-KEM-768 + X25519
+	FeatureAESGCM    uint16 = 1 << 1 // Suite AES-256-GCM soportada
+	FeatureXChaCha   uint16 = 1 << 2 // Suite XChaCha20-Poly1305 soportada
+	FeatureFragment  uint16 = 1 << 3 // Reensambla mensajes MsgTypeFragment
+)
+
+// HandshakeExt es la extensión opcional tras la parte clásica del handshake.
+// Version es la más alta que soporta el emisor y MinVersion la más baja que
+// acepta. Cipher es la suite preferida (Init) o la elegida (Resp). Timestamp
+// (TAI64N) crece con cada handshake del emisor: el receptor descarta los que
+// no sean más nuevos que el último que aceptó de él. Random es nuevo en cada
+// handshake (los reintentos lo repiten): entra en el KDF, así que cada sesión
+// tiene su clave aunque sea clásica y las claves estáticas no cambien. En un
+// Init, KEM es la clave de encapsulación ML-KEM efímera del iniciador; en un
+// Resp, el ciphertext encapsulado contra ella.
+type HandshakeExt struct {
+	Version    uint8
+	MinVersion uint8
+	Features   uint16
+	Cipher     uint8
+	Random     [RandomSize]byte
+	Timestamp  [TimestampSize]byte
+	KEM        []byte
+}
+
+// TAI64N codifica t en formato TAI64N: comparar los bytes es comparar los
+// instantes.
+func TAI64N(t time.Time) (ts [TimestampSize]byte) {
+	binary.BigEndian.PutUint64(ts[:8], uint64(t.Unix())+tai64Base)
+	binary.BigEndian.PutUint32(ts[8:], uint32(t.Nanosecond()))
+	return ts
+}
+
+// NegotiateVersion elige la versión más alta común a ambos rangos.
+// ok=false si no se solapan.
+func NegotiateVersion(localMin, localMax, remoteMin, remoteMax uint8) (version uint8, ok bool) {
+	version = min(localMax, remoteMax)
+	return version, version >= max(localMin, remoteMin)
+}
+
+// SessionContext devuelve el contexto del KDF para una versión. Por sí solo
+// no impide un downgrade: lo que se negocia (versiones, features, suite, KEM)
+// viaja en las extensiones, y es su transcripción (AppendTranscript) la que
+// entra en el KDF de v2+. Quitar la extensión entera lleva a v1, sin
+// transcripción: eso lo impide el engine, que no acepta v1 de un peer que ya
+// negoció v2.
+func SessionContext(version uint8) string {
+	return fmt.Sprintf("taltun-session-v%d", version)
+}
+
+// AppendTranscript añade a dst la extensión en forma canónica, para el KDF de
+// sesión: Init y Resp van ambos, así que alterar cualquier campo negociado
+// deja a cada lado con una clave distinta. El Timestamp no entra: cambia en
+// cada reintento de un mismo handshake.
+func AppendTranscript(dst []byte, ext *HandshakeExt) []byte {
+	dst = append(dst, ext.Version, ext.MinVersion)
+	dst = binary.BigEndian.AppendUint16(dst, ext.Features)
+	dst = append(dst, ext.Cipher)
+	dst = append(dst, ext.Random[:]...)
+	dst = binary.BigEndian.AppendUint16(dst, uint16(len(ext.KEM)))
+	return append(dst, ext.KEM...)
+}
+
 // EncodeHandshake serializa un mensaje de inicio de conexión.
 // Soporta un campo opcional 'cookie' para protección DoS.
 func EncodeHandshake(dst []byte, msgType uint8, localIndex uint32, pubKey []byte, cookie []byte) (int, error) {
@@ -33,6 +122,67 @@ func EncodeHandshake(dst []byte, msgType uint8, localIndex uint32, pubKey []byte
 	return requiredSize, nil
 }
 
+// EncodeHandshakeExt serializa un handshake con extensión (ext puede ser nil).
+// Con extensión, el llamante debe completar el mensaje con AppendAuth y
+// AppendMACs.
+func EncodeHandshakeExt(dst []byte, msgType uint8, localIndex uint32, pubKey []byte, cookie []byte, ext *HandshakeExt) (int, error) {
+	if ext == nil {
+		return EncodeHandshake(dst, msgType, localIndex, pubKey, cookie)
+	}
+	if len(ext.KEM) > 0xFFFF {
+		return 0, errors.New("kem payload too large")
+	}
+	requiredSize := handshakeExtOffset + handshakeExtHeader + len(ext.KEM)
+	if len(dst) < requiredSize {
+		return 0, errors.New("buffer too small")
+	}
+
+	if _, err := EncodeHandshake(dst, msgType, localIndex, pubKey, nil); err != nil {
+		return 0, err
+	}
+	clear(dst[HandshakeBaseSize:handshakeExtOffset])
+	copy(dst[HandshakeBaseSize:handshakeExtOffset], cookie)
+
+	dst[handshakeExtOffset] = ext.Version
+	dst[handshakeExtOffset+1] = ext.MinVersion
+	binary.BigEndian.PutUint16(dst[handshakeExtOffset+2:], ext.Features)
+	dst[handshakeExtOffset+4] = ext.Cipher
+	copy(dst[handshakeExtOffset+5:], ext.Random[:])
+	copy(dst[handshakeExtOffset+21:], ext.Timestamp[:])
+	binary.BigEndian.PutUint16(dst[handshakeExtOffset+33:], uint16(len(ext.KEM)))
+	copy(dst[handshakeExtOffset+handshakeExtHeader:], ext.KEM)
+
+	return requiredSize, nil
+}
+
+// ParseHandshakeExt extrae la extensión si el mensaje la trae. src es el
+// mensaje completo, con la etiqueta y los MACs del final (ver mac.go):
+// ok=false si la longitud no encaja exactamente.
+// El KEM devuelto es una vista sobre src (zero-copy).
+func ParseHandshakeExt(src []byte) (ext HandshakeExt, ok bool) {
+	if len(src) < handshakeExtOffset+handshakeExtHeader+HandshakeAuthSize+HandshakeMACsSize {
+		return ext, false
+	}
+	ext.Version = src[handshakeExtOffset]
+	ext.MinVersion = src[handshakeExtOffset+1]
+	if ext.Version < ProtocolVersion2 || ext.MinVersion > ext.Version {
+		return ext, false
+	}
+	ext.Features = binary.BigEndian.Uint16(src[handshakeExtOffset+2:])
+	ext.Cipher = src[handshakeExtOffset+4]
+	copy(ext.Random[:], src[handshakeExtOffset+5:])
+	copy(ext.Timestamp[:], src[handshakeExtOffset+21:])
+	kemLen := int(binary.BigEndian.Uint16(src[handshakeExtOffset+33:]))
+	start := handshakeExtOffset + handshakeExtHeader
+	if len(src) != start+kemLen+HandshakeAuthSize+HandshakeMACsSize {
+		return ext, false
+	}
+	if kemLen > 0 {
+		ext.KEM = src[start : start+kemLen]
+	}
+	return ext, true
+}
+
 // ParseHandshake decodifica el mensaje.
 // Retorna la cookie si está presente en el paquete.
 func ParseHandshake(src []byte) (senderIndex uint32, pubKey []byte, cookie []byte, err error) {
@@ -45,13 +195,17 @@ func ParseHandshake(src []byte) (senderIndex uint32, pubKey []byte, cookie []byt
 	
 	if len(src) >= HandshakeBaseSize+CookieSize {
 		cookie = src[37 : 37+CookieSize]
+		// Hueco vacío de un handshake con extensión: no hay cookie.
+		if isZero(cookie) {
+			cookie = nil
+		}
 	}
 	
 	return senderIndex, pubKey, cookie, nil
 }
 
-// EncodeCookieReply crea el paquete de respuesta de cookie.
-// Estructura: Type (1) + Cookie (16)
+// EncodeCookieReply crea el paquete de respuesta de cookie legacy (v1), en claro.
+// Estructura: Type (1) + Cookie (16). Los handshakes v2+ usan SealCookieReply.
 func EncodeCookieReply(dst []byte, cookie []byte) (int, error) {
 	if len(dst) < 1+len(cookie) {
 		return 0, errors.New("buffer too small for cookie reply")
@@ -61,10 +215,19 @@ func EncodeCookieReply(dst []byte, cookie []byte) (int, error) {
 	return 1 + len(cookie), nil
 }
 
-// ParseCookieReply extrae la cookie de un paquete de respuesta.
+// ParseCookieReply extrae la cookie de un paquete de respuesta legacy.
 func ParseCookieReply(src []byte) ([]byte, error) {
 	if len(src) < 1+CookieSize {
 		return nil, errors.New("packet too small for cookie reply")
 	}
 	return src[1 : 1+CookieSize], nil
 }
+
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -17,6 +17,8 @@ const (
 	MsgTypeHandshakeResp  uint8 = 0x02 // Servidor -> Cliente (Hola, esta es la mia)
 	MsgTypeData           uint8 = 0x03 // Tráfico VPN Cifrado
 	MsgTypeCookieReply    uint8 = 0x04 // Servidor -> Cliente (Estás rate-limited, usa esta cookie)
+	MsgTypeControl        uint8 = 0x05 // Mensaje de control cifrado (mismo formato que Data)
+	MsgTypeFragment       uint8 = 0x06 // Trozo de un mensaje Data cifrado que no cabe en el camino
 )
 
 var (
@@ -25,6 +27,11 @@ var (
 
 // EncodeDataHeader escribe la cabecera en el buffer dst.
 func EncodeDataHeader(dst []byte, sessionID uint32, nonce []byte) (int, error) {
+	return EncodeHeader(dst, MsgTypeData, sessionID, nonce)
+}
+
+// EncodeHeader escribe una cabecera cifrada (Data o Control) en el buffer dst.
+func EncodeHeader(dst []byte, msgType uint8, sessionID uint32, nonce []byte) (int, error) {
 	if len(dst) < HeaderSize {
 		return 0, ErrBufferTooSmall
 	}
@@ -32,7 +39,7 @@ func EncodeDataHeader(dst []byte, sessionID uint32, nonce []byte) (int, error) {
 		return 0, errors.New("invalid nonce size")
 	}
 
-	dst[0] = MsgTypeData
+	dst[0] = msgType
 	binary.BigEndian.PutUint32(dst[1:5], sessionID)
 	copy(dst[5:17], nonce)
 
@@ -0,0 +1,179 @@
+package protocol
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// --- MACs DEL HANDSHAKE (estilo WireGuard) ---
+//
+// Todo handshake extendido (v2+) termina en una etiqueta y dos MACs de 16 bytes:
+//
+//	... | auth (16) | mac1 (16) | mac2 (16)
+//
+//   - auth = MAC(clave de autenticación, mensaje[:auth]). La clave sale del DH
+//     entre las claves estáticas de ambos (y la PSK): solo el dueño de la
+//     clave privada del emisor puede generarla, mientras que mac1 lo calcula
+//     cualquiera que conozca la clave pública del receptor. Impide alterar la
+//     extensión (timestamp, versiones, suite, KEM) y reenviarla como nueva.
+//   - mac1 = MAC(Hash("mac1----" | pubkey del receptor), mensaje[:mac1])
+//     Solo quien conoce la clave pública del receptor puede generarlo, así que
+//     un escáner no consigue ni que hagamos Curve25519. Es barato de comprobar.
+//   - mac2 = MAC(cookie, mensaje[:mac2]) con la última cookie recibida, o ceros
+//     si no hay. Bajo carga el receptor solo atiende mensajes con mac2 válido:
+//     prueba de que el emisor recibe tráfico en su IP.
+//
+// La cookie viaja cifrada en el Cookie Reply:
+//
+//	Type (1) | Nonce (24) | XChaCha20-Poly1305(cookie) (16 + 16 tag)
+//
+// con clave Hash("cookie--" | pubkey del que responde) y como dato adicional
+// el mac1 del handshake que la provocó. Solo el iniciador real (que conoce ese
+// mac1) la acepta: un atacante en el camino no puede inyectar cookies.
+
+const (
+	MACSize           = 16
+	HandshakeMACsSize = 2 * MACSize
+	HandshakeAuthSize = MACSize
+
+	// Cookie Reply cifrado (v2+) y el formato legacy en claro (v1)
+	CookieReplySize       = 1 + chacha20poly1305.NonceSizeX + CookieSize + chacha20poly1305.Overhead
+	LegacyCookieReplySize = 1 + CookieSize
+
+	labelMAC1   = "mac1----"
+	labelCookie = "cookie--"
+)
+
+var (
+	ErrInvalidMAC         = errors.New("invalid handshake mac")
+	ErrInvalidCookieReply = errors.New("invalid cookie reply")
+)
+
+// MAC1Key deriva la clave de mac1 de la clave pública estática del receptor.
+func MAC1Key(pubKey []byte) [32]byte {
+	return labelKey(labelMAC1, pubKey)
+}
+
+// CookieKey deriva la clave de cifrado de los Cookie Reply de la clave pública
+// estática de quien los envía (el receptor del handshake).
+func CookieKey(pubKey []byte) [32]byte {
+	return labelKey(labelCookie, pubKey)
+}
+
+func labelKey(label string, pubKey []byte) [32]byte {
+	h, _ := blake2s.New256(nil)
+	h.Write([]byte(label))
+	h.Write(pubKey)
+	var k [32]byte
+	h.Sum(k[:0])
+	return k
+}
+
+// AppendAuth añade la etiqueta de autenticación tras el mensaje buf[:n] y
+// devuelve la nueva longitud.
+func AppendAuth(buf []byte, n int, authKey *[32]byte) (int, error) {
+	if len(buf) < n+HandshakeAuthSize {
+		return 0, ErrBufferTooSmall
+	}
+	computeMAC(buf[n:n+HandshakeAuthSize], authKey[:], buf[:n])
+	return n + HandshakeAuthSize, nil
+}
+
+// VerifyAuth comprueba la etiqueta de un mensaje completo (con sus MACs).
+func VerifyAuth(msg []byte, authKey *[32]byte) bool {
+	if len(msg) < HandshakeAuthSize+HandshakeMACsSize {
+		return false
+	}
+	off := len(msg) - HandshakeMACsSize - HandshakeAuthSize
+	var expected [MACSize]byte
+	computeMAC(expected[:], authKey[:], msg[:off])
+	return subtle.ConstantTimeCompare(expected[:], msg[off:off+HandshakeAuthSize]) == 1
+}
+
+// AppendMACs añade mac1 y mac2 tras el mensaje buf[:n] y devuelve la nueva
+// longitud. cookie puede ser nil (mac2 a ceros).
+func AppendMACs(buf []byte, n int, mac1Key *[32]byte, cookie []byte) (int, error) {
+	if len(buf) < n+HandshakeMACsSize {
+		return 0, ErrBufferTooSmall
+	}
+	computeMAC(buf[n:n+MACSize], mac1Key[:], buf[:n])
+	mac2 := buf[n+MACSize : n+HandshakeMACsSize]
+	if len(cookie) > 0 {
+		computeMAC(mac2, cookie, buf[:n+MACSize])
+	} else {
+		clear(mac2)
+	}
+	return n + HandshakeMACsSize, nil
+}
+
+// VerifyMAC1 comprueba el mac1 de un mensaje completo (con sus MACs).
+func VerifyMAC1(msg []byte, mac1Key *[32]byte) bool {
+	if len(msg) < HandshakeMACsSize {
+		return false
+	}
+	off := len(msg) - HandshakeMACsSize
+	var expected [MACSize]byte
+	computeMAC(expected[:], mac1Key[:], msg[:off])
+	return subtle.ConstantTimeCompare(expected[:], msg[off:off+MACSize]) == 1
+}
+
+// VerifyMAC2 comprueba el mac2 de un mensaje completo con la cookie dada.
+func VerifyMAC2(msg []byte, cookie []byte) bool {
+	if len(msg) < HandshakeMACsSize || len(cookie) == 0 {
+		return false
+	}
+	off := len(msg) - MACSize
+	var expected [MACSize]byte
+	computeMAC(expected[:], cookie, msg[:off])
+	return subtle.ConstantTimeCompare(expected[:], msg[off:]) == 1
+}
+
+// MAC1 devuelve una vista del mac1 de un mensaje completo.
+func MAC1(msg []byte) []byte {
+	off := len(msg) - HandshakeMACsSize
+	return msg[off : off+MACSize]
+}
+
+// SealCookieReply crea un Cookie Reply cifrado con cookieKey y ligado al mac1
+// del handshake que lo provocó.
+func SealCookieReply(dst []byte, cookieKey *[32]byte, mac1, cookie []byte) (int, error) {
+	if len(dst) < CookieReplySize {
+		return 0, ErrBufferTooSmall
+	}
+	if len(cookie) != CookieSize || len(mac1) != MACSize {
+		return 0, ErrInvalidCookieReply
+	}
+	dst[0] = MsgTypeCookieReply
+	nonce := dst[1 : 1+chacha20poly1305.NonceSizeX]
+	if _, err := rand.Read(nonce); err != nil {
+		return 0, err
+	}
+	aead, _ := chacha20poly1305.NewX(cookieKey[:])
+	aead.Seal(dst[1+len(nonce):1+len(nonce)], nonce, cookie, mac1)
+	return CookieReplySize, nil
+}
+
+// OpenCookieReply descifra un Cookie Reply con la clave del peer y el mac1 de
+// nuestro último handshake hacia él. Devuelve la cookie (copia nueva).
+func OpenCookieReply(src []byte, cookieKey *[32]byte, mac1 []byte) ([]byte, error) {
+	if len(src) != CookieReplySize || src[0] != MsgTypeCookieReply || len(mac1) != MACSize {
+		return nil, ErrInvalidCookieReply
+	}
+	nonce := src[1 : 1+chacha20poly1305.NonceSizeX]
+	aead, _ := chacha20poly1305.NewX(cookieKey[:])
+	cookie, err := aead.Open(nil, nonce, src[1+len(nonce):], mac1)
+	if err != nil {
+		return nil, ErrInvalidCookieReply
+	}
+	return cookie, nil
+}
+
+func computeMAC(dst, key, data []byte) {
+	h, _ := blake2s.New128(key)
+	h.Write(data)
+	h.Sum(dst[:0])
+}
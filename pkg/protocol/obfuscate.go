@@ -0,0 +1,168 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/rand/v2"
+
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/chacha20"
+)
+
+// --- OFUSCACIÓN (anti-DPI) ---
+//
+// Capa opcional por encima del protocolo: no toca el cifrado interno, solo
+// oculta lo que un DPI usa para reconocernos (byte de tipo en claro, prefijo
+// 0xCAFEBABE del nonce, tamaños exactos de los handshakes, claves ML-KEM en
+// claro).
+//
+// Formato en el cable:
+//
+//	Salt (12, aleatorio) | Máscara( PadLen (2) | Mensaje | Padding )
+//
+// La máscara es el keystream ChaCha20(clave, salt). Los handshakes y cookie
+// replies se enmascaran enteros (la clave pública y el ciphertext ML-KEM tienen
+// estructura reconocible) y se rellenan hasta el primer tamaño de
+// obfsHandshakeBuckets que los contiene: Init y Resp, clásicos o híbridos,
+// quedan en dos tamaños posibles. De Data/Control/Fragment solo se enmascaran
+// los primeros obfsMaskLen bytes (la cabecera): el resto ya es ciphertext y
+// enmascararlo solo costaría CPU. El padding es keystream, así que tampoco
+// deja ceros reconocibles.
+
+const (
+	ObfsSaltSize = 12
+	ObfsOverhead = ObfsSaltSize + 2 // Salt + PadLen, sin contar el padding
+
+	// Padding máximo por defecto para Data/Control/Fragment. Los handshakes y
+	// cookie replies llevan su bucket más hasta ObfsHandshakePadding.
+	DefaultObfsPadding   = 16
+	ObfsHandshakePadding = 64
+
+	obfsMaskLen = 2 + HeaderSize
+)
+
+// obfsHandshakeBuckets son los tamaños (PadLen + mensaje + padding) a los que
+// se rellenan los handshakes. El mayor cabe, con salt y extra, en un
+// datagrama de 1500 bytes, y contiene un Init híbrido.
+var obfsHandshakeBuckets = [...]int{256, 1344}
+
+var (
+	ErrObfsBufferTooSmall = errors.New("buffer too small for obfuscation")
+	errObfsMalformed      = errors.New("malformed obfuscated packet")
+)
+
+// Obfuscator enmascara y desenmascara mensajes con una clave pre-compartida.
+// Es inmutable tras crearse: seguro para uso concurrente.
+type Obfuscator struct {
+	key        [32]byte
+	maxPadding int
+}
+
+// NewObfuscator deriva la clave de máscara a partir de una frase compartida.
+// maxPadding es el relleno aleatorio máximo de los paquetes de datos
+// (0 = sin relleno; los handshakes se rellenan igualmente).
+func NewObfuscator(secret string, maxPadding int) (*Obfuscator, error) {
+	if secret == "" {
+		return nil, errors.New("obfuscation key vacía")
+	}
+	if maxPadding < 0 || maxPadding > 0xFFFF {
+		return nil, errors.New("obfuscation padding fuera de rango")
+	}
+	o := &Obfuscator{maxPadding: maxPadding}
+
+	// Dominio separado del KDF de sesión: la clave de máscara no protege nada
+	// criptográficamente, pero no debe poder relacionarse con él.
+	h, _ := blake2s.New256(nil)
+	h.Write([]byte("taltun-obfs-v1"))
+	h.Write([]byte(secret))
+	h.Sum(o.key[:0])
+	return o, nil
+}
+
+// Overhead devuelve los bytes extra que puede añadir Wrap a un paquete de datos.
+func (o *Obfuscator) Overhead() int {
+	return ObfsOverhead + o.maxPadding
+}
+
+// Wrap ofusca en el sitio el mensaje buf[:n] y devuelve la nueva longitud.
+// buf debe tener capacidad para n + ObfsOverhead + el padding máximo.
+func (o *Obfuscator) Wrap(buf []byte, n int) (int, error) {
+	if n < 1 {
+		return 0, ErrObfsBufferTooSmall
+	}
+
+	handshake := isObfsHandshake(buf[0])
+	maskLen, maxPad, pad := obfsMaskLen, o.maxPadding, 0
+	if handshake {
+		maskLen, maxPad = 2+n, ObfsHandshakePadding
+		for _, b := range obfsHandshakeBuckets {
+			if 2+n <= b {
+				pad = b - (2 + n)
+				break
+			}
+		}
+	}
+	if maxPad > 0 {
+		pad += rand.IntN(maxPad + 1)
+	}
+
+	total := ObfsOverhead + n + pad
+	if cap(buf) < total {
+		return 0, ErrObfsBufferTooSmall
+	}
+	buf = buf[:total]
+
+	copy(buf[ObfsOverhead:], buf[:n])
+
+	salt := buf[:ObfsSaltSize]
+	binary.LittleEndian.PutUint64(salt[0:8], rand.Uint64())
+	binary.LittleEndian.PutUint32(salt[8:12], rand.Uint32())
+	binary.BigEndian.PutUint16(buf[ObfsSaltSize:], uint16(pad))
+
+	c, _ := chacha20.NewUnauthenticatedCipher(o.key[:], salt)
+	masked := buf[ObfsSaltSize : ObfsSaltSize+min(2+n, maskLen)]
+	c.XORKeyStream(masked, masked)
+
+	if pad > 0 {
+		padding := buf[total-pad:]
+		clear(padding)
+		c.XORKeyStream(padding, padding)
+	}
+	return total, nil
+}
+
+// Unwrap desofusca en el sitio y devuelve el mensaje original (subslice de pkt).
+// Un paquete que no encaja (clave distinta, sondeo, basura) da error: el
+// llamante debe descartarlo en silencio.
+func (o *Obfuscator) Unwrap(pkt []byte) ([]byte, error) {
+	if len(pkt) < ObfsOverhead+1 {
+		return nil, errObfsMalformed
+	}
+
+	c, _ := chacha20.NewUnauthenticatedCipher(o.key[:], pkt[:ObfsSaltSize])
+	masked := pkt[ObfsSaltSize : ObfsSaltSize+min(len(pkt)-ObfsSaltSize, obfsMaskLen)]
+	c.XORKeyStream(masked, masked)
+	// Los handshakes van enmascarados enteros: el keystream sigue donde quedó
+	// (el padding, también keystream, vuelve a ceros y se descarta).
+	if isObfsHandshake(pkt[ObfsOverhead]) {
+		rest := pkt[ObfsSaltSize+len(masked):]
+		c.XORKeyStream(rest, rest)
+	}
+
+	pad := int(binary.BigEndian.Uint16(pkt[ObfsSaltSize:]))
+	end := len(pkt) - pad
+	if end <= ObfsOverhead {
+		return nil, errObfsMalformed
+	}
+
+	msg := pkt[ObfsOverhead:end]
+	if msg[0] < MsgTypeHandshakeInit || msg[0] > MsgTypeFragment {
+		return nil, errObfsMalformed
+	}
+	return msg, nil
+}
+
+// isObfsHandshake indica si un tipo de mensaje se enmascara entero.
+func isObfsHandshake(t uint8) bool {
+	return t != MsgTypeData && t != MsgTypeControl && t != MsgTypeFragment
+}
@@ -1,7 +1,9 @@
 package protocol
 
 import (
+	"net"
 	"testing"
+	"time"
 )
 
 // Test funcional básico
@@ -73,3 +75,375 @@ func BenchmarkEncodeHeader(b *testing.B) {
 		_, _ = EncodeDataHeader(buf, sid, nonce)
 	}
 }
+
+func TestDNSConfigRoundTrip(t *testing.T) {
+	buf := make([]byte, 256)
+	servers := []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("fd00::53")}
+	domains := []string{"corp.local", "vpn.example"}
+
+	n, err := EncodeDNSConfig(buf, servers, domains)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	gotServers, gotDomains, err := ParseDNSConfig(buf[:n])
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(gotServers) != 2 || !gotServers[0].Equal(servers[0]) || !gotServers[1].Equal(servers[1]) {
+		t.Errorf("Wrong servers: %v", gotServers)
+	}
+	if len(gotDomains) != 2 || gotDomains[0] != domains[0] || gotDomains[1] != domains[1] {
+		t.Errorf("Wrong domains: %v", gotDomains)
+	}
+
+	// Un TLV truncado debe rechazarse
+	if _, _, err := ParseDNSConfig(buf[:n-1]); err == nil {
+		t.Errorf("Expected error on truncated message")
+	}
+}
+
+func TestPMTUControlRoundTrip(t *testing.T) {
+	buf := make([]byte, 1400)
+	for i := range buf {
+		buf[i] = 0xFF // El relleno debe quedar a cero
+	}
+
+	n, err := EncodePMTUProbe(buf, 42, 1400)
+	if err != nil || n != 1400 {
+		t.Fatalf("EncodePMTUProbe = %d, %v", n, err)
+	}
+	if typ, id, _, ok := ParsePMTU(buf[:n]); !ok || typ != ControlPMTUProbe || id != 42 {
+		t.Fatalf("Probe parsed as %d %d %v", typ, id, ok)
+	}
+	// Una sonda no es configuración DNS (el relleno termina la lista)
+	if servers, _, err := ParseDNSConfig(buf[:n]); err != nil || len(servers) != 0 {
+		t.Errorf("Probe misparsed as DNS config: %v %v", servers, err)
+	}
+
+	n, err = EncodePMTUAck(buf, 42, 1400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ, id, size, ok := ParsePMTU(buf[:n]); !ok || typ != ControlPMTUAck || id != 42 || size != 1400 {
+		t.Fatalf("Ack parsed as %d %d %d %v", typ, id, size, ok)
+	}
+
+	dns := make([]byte, 64)
+	n, _ = EncodeDNSConfig(dns, []net.IP{net.ParseIP("10.0.0.1")}, nil)
+	if _, _, _, ok := ParsePMTU(dns[:n]); ok {
+		t.Errorf("DNS config parsed as PMTU message")
+	}
+}
+
+func TestObfuscateRoundTrip(t *testing.T) {
+	o, err := NewObfuscator("secreto compartido", DefaultObfsPadding)
+	if err != nil {
+		t.Fatalf("NewObfuscator failed: %v", err)
+	}
+
+	pubKey := make([]byte, 32)
+	for i := range pubKey {
+		pubKey[i] = byte(i)
+	}
+	orig := make([]byte, 128)
+	n, _ := EncodeHandshake(orig, MsgTypeHandshakeInit, 0x0A000001, pubKey, nil)
+
+	sizes := make(map[int]bool)
+	for i := 0; i < 20; i++ {
+		buf := make([]byte, 512)
+		copy(buf, orig[:n])
+
+		wn, err := o.Wrap(buf, n)
+		if err != nil {
+			t.Fatalf("Wrap failed: %v", err)
+		}
+		sizes[wn] = true
+
+		// Ni el tipo ni la clave pública deben verse en claro.
+		if string(buf[:wn]) == string(orig[:n]) || containsBytes(buf[:wn], pubKey[:16]) {
+			t.Fatalf("Handshake visible after Wrap")
+		}
+
+		msg, err := o.Unwrap(buf[:wn])
+		if err != nil {
+			t.Fatalf("Unwrap failed: %v", err)
+		}
+		if string(msg) != string(orig[:n]) {
+			t.Fatalf("Round trip mismatch")
+		}
+	}
+	if len(sizes) < 2 {
+		t.Errorf("Handshake size not randomized: %v", sizes)
+	}
+
+	// Con otra clave el paquete no debe aceptarse (salvo colisión improbable).
+	other, _ := NewObfuscator("otra clave", 0)
+	buf := make([]byte, 512)
+	copy(buf, orig[:n])
+	wn, _ := o.Wrap(buf, n)
+	if msg, err := other.Unwrap(buf[:wn]); err == nil && string(msg) == string(orig[:n]) {
+		t.Errorf("Unwrap with wrong key succeeded")
+	}
+}
+
+func TestObfuscateHybridHandshake(t *testing.T) {
+	o, _ := NewObfuscator("secreto compartido", DefaultObfsPadding)
+
+	// Un Init con 1184 bytes de "clave ML-KEM" reconocible al final.
+	kem := make([]byte, 1184)
+	for i := range kem {
+		kem[i] = byte(i * 7)
+	}
+	orig := make([]byte, 1300)
+	n, _ := EncodeHandshake(orig, MsgTypeHandshakeInit, 0x0A000001, make([]byte, 32), nil)
+	n += copy(orig[n:], kem)
+
+	buf := make([]byte, 2048)
+	copy(buf, orig[:n])
+	wn, err := o.Wrap(buf, n)
+	if err != nil {
+		t.Fatalf("Wrap failed: %v", err)
+	}
+	if containsBytes(buf[:wn], kem[len(kem)-32:]) {
+		t.Errorf("KEM key visible after Wrap")
+	}
+	if min, max := ObfsSaltSize+obfsHandshakeBuckets[1], ObfsSaltSize+obfsHandshakeBuckets[1]+ObfsHandshakePadding; wn < min || wn > max {
+		t.Errorf("Wrapped size %d outside bucket [%d, %d]", wn, min, max)
+	}
+	msg, err := o.Unwrap(buf[:wn])
+	if err != nil || string(msg) != string(orig[:n]) {
+		t.Fatalf("Round trip mismatch (%v)", err)
+	}
+}
+
+func containsBytes(haystack, needle []byte) bool {
+	for i := 0; i+len(needle) <= len(haystack); i++ {
+		if string(haystack[i:i+len(needle)]) == string(needle) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestHandshakeExtRoundTrip(t *testing.T) {
+	buf := make([]byte, 2048)
+	pubKey := make([]byte, 32)
+	cookie := []byte("0123456789abcdef")
+	kem := make([]byte, 1184)
+	kem[0], kem[len(kem)-1] = 0xAA, 0xBB
+
+	mac1Key := MAC1Key(pubKey)
+	var authKey [32]byte
+	ts := TAI64N(time.Unix(1700000000, 5))
+
+	n, err := EncodeHandshakeExt(buf, MsgTypeHandshakeInit, 7, pubKey, cookie, &HandshakeExt{Version: CurrentProtocolVersion, MinVersion: MinProtocolVersion, Features: FeatureHybridKEM, Cipher: 2, Random: [RandomSize]byte{15: 0x5A}, Timestamp: ts, KEM: kem})
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if n, err = AppendAuth(buf, n, &authKey); err != nil {
+		t.Fatalf("AppendAuth failed: %v", err)
+	}
+	if n, err = AppendMACs(buf, n, &mac1Key, nil); err != nil {
+		t.Fatalf("AppendMACs failed: %v", err)
+	}
+
+	// Un parser antiguo sigue viendo la parte clásica y la cookie.
+	idx, _, gotCookie, err := ParseHandshake(buf[:n])
+	if err != nil || idx != 7 || string(gotCookie) != string(cookie) {
+		t.Fatalf("Classic part mismatch: idx=%d cookie=%q err=%v", idx, gotCookie, err)
+	}
+
+	ext, ok := ParseHandshakeExt(buf[:n])
+	if !ok || ext.Version != CurrentProtocolVersion || ext.MinVersion != MinProtocolVersion || ext.Features != FeatureHybridKEM || ext.Cipher != 2 || ext.Random[15] != 0x5A || ext.Timestamp != ts {
+		t.Fatalf("Extension mismatch: ok=%v %+v", ok, ext)
+	}
+	if len(ext.KEM) != len(kem) || ext.KEM[0] != 0xAA || ext.KEM[len(kem)-1] != 0xBB {
+		t.Fatalf("KEM payload mismatch: len=%d", len(ext.KEM))
+	}
+
+	// Sin cookie el hueco va a ceros y no debe interpretarse como cookie.
+	n, _ = EncodeHandshakeExt(buf, MsgTypeHandshakeResp, 7, pubKey, nil, &HandshakeExt{Version: CurrentProtocolVersion, MinVersion: MinProtocolVersion})
+	n, _ = AppendAuth(buf, n, &authKey)
+	n, _ = AppendMACs(buf, n, &mac1Key, nil)
+	if _, _, gotCookie, _ := ParseHandshake(buf[:n]); gotCookie != nil {
+		t.Errorf("Empty cookie slot parsed as cookie")
+	}
+
+	// Un handshake clásico no trae extensión.
+	n, _ = EncodeHandshake(buf, MsgTypeHandshakeInit, 7, pubKey, nil)
+	if _, ok := ParseHandshakeExt(buf[:n]); ok {
+		t.Errorf("Classic handshake parsed as extended")
+	}
+
+	// El orden de los bytes es el de los instantes.
+	later := TAI64N(time.Unix(1700000000, 6))
+	if string(later[:]) <= string(ts[:]) {
+		t.Errorf("TAI64N not ordered: % x <= % x", later, ts)
+	}
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	cases := []struct {
+		lMin, lMax, rMin, rMax uint8
+		want                   uint8
+		ok                     bool
+	}{
+		{1, 2, 1, 2, 2, true},
+		{1, 2, 1, 1, 1, true},  // Peer legacy
+		{2, 2, 1, 1, 1, false}, // Exigimos v2, el peer solo habla v1
+		{1, 3, 2, 2, 2, true},
+		{3, 3, 1, 2, 2, false},
+	}
+	for _, c := range cases {
+		got, ok := NegotiateVersion(c.lMin, c.lMax, c.rMin, c.rMax)
+		if ok != c.ok || (ok && got != c.want) {
+			t.Errorf("NegotiateVersion(%d,%d,%d,%d) = %d,%v; want %d,%v", c.lMin, c.lMax, c.rMin, c.rMax, got, ok, c.want, c.ok)
+		}
+	}
+
+	// v1 mantiene el contexto histórico (compatibilidad con nodos legacy).
+	if SessionContext(ProtocolVersionLegacy) != "taltun-session-v1" {
+		t.Errorf("Legacy context changed: %s", SessionContext(ProtocolVersionLegacy))
+	}
+}
+
+func TestTranscript(t *testing.T) {
+	base := HandshakeExt{Version: CurrentProtocolVersion, MinVersion: MinProtocolVersion, Features: FeatureHybridKEM, Cipher: 1, KEM: []byte{1, 2, 3}}
+	want := string(AppendTranscript(nil, &base))
+
+	// El timestamp cambia en cada reintento: no forma parte de la transcripción.
+	retry := base
+	retry.Timestamp = TAI64N(time.Now())
+	if string(AppendTranscript(nil, &retry)) != want {
+		t.Errorf("Timestamp changed the transcript")
+	}
+
+	// Cualquier campo negociado sí la cambia.
+	for name, mod := range map[string]func(*HandshakeExt){
+		"min":      func(e *HandshakeExt) { e.MinVersion = CurrentProtocolVersion },
+		"features": func(e *HandshakeExt) { e.Features = 0 },
+		"cipher":   func(e *HandshakeExt) { e.Cipher = 0 },
+		"random":   func(e *HandshakeExt) { e.Random[0] = 1 },
+		"kem":      func(e *HandshakeExt) { e.KEM = nil },
+	} {
+		ext := base
+		mod(&ext)
+		if string(AppendTranscript(nil, &ext)) == want {
+			t.Errorf("Transcript ignores %s", name)
+		}
+	}
+}
+
+func TestHandshakeMACs(t *testing.T) {
+	buf := make([]byte, 256)
+	responderPub := make([]byte, 32)
+	responderPub[0] = 1
+	mac1Key := MAC1Key(responderPub)
+	cookie := []byte("0123456789abcdef")
+
+	authKey := [32]byte{1}
+
+	n, _ := EncodeHandshakeExt(buf, MsgTypeHandshakeInit, 7, make([]byte, 32), nil, &HandshakeExt{Version: CurrentProtocolVersion, MinVersion: MinProtocolVersion})
+	n, _ = AppendAuth(buf, n, &authKey)
+	n, err := AppendMACs(buf, n, &mac1Key, cookie)
+	if err != nil {
+		t.Fatalf("AppendMACs failed: %v", err)
+	}
+	msg := buf[:n]
+
+	if !VerifyAuth(msg, &authKey) {
+		t.Fatalf("Valid auth tag rejected")
+	}
+	if VerifyAuth(msg, &[32]byte{2}) {
+		t.Errorf("Auth tag accepted with wrong key")
+	}
+
+	if !VerifyMAC1(msg, &mac1Key) {
+		t.Fatalf("Valid mac1 rejected")
+	}
+	if !VerifyMAC2(msg, cookie) {
+		t.Fatalf("Valid mac2 rejected")
+	}
+
+	// mac1 con la clave de otro receptor (escáner que no la conoce)
+	otherKey := MAC1Key(make([]byte, 32))
+	if VerifyMAC1(msg, &otherKey) {
+		t.Errorf("mac1 accepted with wrong key")
+	}
+	if VerifyMAC2(msg, []byte("fedcba9876543210")) {
+		t.Errorf("mac2 accepted with wrong cookie")
+	}
+
+	// Cualquier bit alterado invalida mac1
+	msg[5] ^= 0x01
+	if VerifyMAC1(msg, &mac1Key) {
+		t.Errorf("mac1 accepted on tampered message")
+	}
+	if VerifyAuth(msg, &authKey) {
+		t.Errorf("Auth tag accepted on tampered message")
+	}
+}
+
+func TestCookieReplySealed(t *testing.T) {
+	buf := make([]byte, 128)
+	key := CookieKey([]byte("clave-publica-del-responder-0123"))
+	mac1 := []byte("mac1-del-init-01")
+	cookie := []byte("0123456789abcdef")
+
+	n, err := SealCookieReply(buf, &key, mac1, cookie)
+	if err != nil {
+		t.Fatalf("SealCookieReply failed: %v", err)
+	}
+	if n != CookieReplySize {
+		t.Fatalf("Expected size %d, got %d", CookieReplySize, n)
+	}
+
+	got, err := OpenCookieReply(buf[:n], &key, mac1)
+	if err != nil {
+		t.Fatalf("OpenCookieReply failed: %v", err)
+	}
+	if string(got) != string(cookie) {
+		t.Errorf("Cookie mismatch: %x", got)
+	}
+
+	// Ligado al handshake: otro mac1 no la descifra
+	if _, err := OpenCookieReply(buf[:n], &key, []byte("mac1-de-otro-ini")); err == nil {
+		t.Errorf("Cookie reply accepted with wrong mac1")
+	}
+
+	// Clave de otro responder
+	otherKey := CookieKey(make([]byte, 32))
+	if _, err := OpenCookieReply(buf[:n], &otherKey, mac1); err == nil {
+		t.Errorf("Cookie reply accepted with wrong key")
+	}
+
+	// Cookie inyectada en claro (formato legacy) no pasa
+	m, _ := EncodeCookieReply(buf, cookie)
+	if _, err := OpenCookieReply(buf[:m], &key, mac1); err == nil {
+		t.Errorf("Legacy cookie reply accepted as sealed")
+	}
+}
+
+func TestFragmentRoundTrip(t *testing.T) {
+	buf := make([]byte, FragmentHeaderSize+3)
+	if _, err := EncodeFragmentHeader(buf, 0x0A090002, 7, 1, 3); err != nil {
+		t.Fatal(err)
+	}
+	copy(buf[FragmentHeaderSize:], "abc")
+
+	sender, id, index, count, data, err := ParseFragment(buf)
+	if err != nil || sender != 0x0A090002 || id != 7 || index != 1 || count != 3 || string(data) != "abc" {
+		t.Fatalf("ParseFragment = %x %d %d/%d %q %v", sender, id, index, count, data, err)
+	}
+
+	// Índice fuera de rango o un único trozo no son válidos
+	buf[9] = 3
+	if _, _, _, _, _, err := ParseFragment(buf); err == nil {
+		t.Errorf("Index >= count accepted")
+	}
+	buf[9], buf[10] = 0, 1
+	if _, _, _, _, _, err := ParseFragment(buf); err == nil {
+		t.Errorf("Single fragment accepted")
+	}
+}
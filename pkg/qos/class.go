@@ -0,0 +1,89 @@
+package qos
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Class es la prioridad de un paquete interno, según su DSCP. Las clases se
+// sirven en prioridad estricta: ClassHigh antes que ClassNormal antes que
+// ClassBulk.
+type Class uint8
+
+const (
+	ClassHigh   Class = iota // CS5-CS7, VA, EF: voz, vídeo interactivo, control de red
+	ClassNormal              // Todo lo demás
+	ClassBulk                // CS1 y LE (RFC 8622): backups, descargas en segundo plano
+
+	NumClasses = 3
+)
+
+// DSCP de referencia (RFC 4594, RFC 8622)
+const (
+	dscpLE  = 1
+	dscpCS1 = 8
+	dscpCS5 = 40
+)
+
+func (c Class) String() string {
+	switch c {
+	case ClassHigh:
+		return "high"
+	case ClassNormal:
+		return "normal"
+	case ClassBulk:
+		return "bulk"
+	}
+	return "?"
+}
+
+// ClassOf clasifica un paquete IPv4 o IPv6 por su DSCP.
+func ClassOf(pkt []byte) Class {
+	if len(pkt) < 2 {
+		return ClassNormal
+	}
+	var dscp byte
+	switch pkt[0] >> 4 {
+	case 4:
+		dscp = pkt[1] >> 2
+	case 6:
+		dscp = (pkt[0]&0x0f)<<2 | pkt[1]>>6
+	default:
+		return ClassNormal
+	}
+
+	switch {
+	case dscp >= dscpCS5:
+		return ClassHigh
+	case dscp == dscpCS1 || dscp == dscpLE:
+		return ClassBulk
+	}
+	return ClassNormal
+}
+
+// ParseRate interpreta un caudal en bits por segundo ("512kbit", "20mbit",
+// "1.5gbit" o un número sin unidad) y lo devuelve en bytes por segundo.
+func ParseRate(s string) (uint64, error) {
+	v := strings.ToLower(strings.TrimSpace(s))
+	mult := 1.0
+	for _, u := range []struct {
+		suffix string
+		mult   float64
+	}{{"gbit", 1e9}, {"mbit", 1e6}, {"kbit", 1e3}, {"bit", 1}} {
+		if strings.HasSuffix(v, u.suffix) {
+			v, mult = strings.TrimSpace(strings.TrimSuffix(v, u.suffix)), u.mult
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("caudal invalido: %q (ej. \"20mbit\")", s)
+	}
+	bytes := uint64(n * mult / 8)
+	if bytes == 0 {
+		return 0, fmt.Errorf("caudal demasiado bajo: %q", s)
+	}
+	return bytes, nil
+}
@@ -0,0 +1,372 @@
+package qos
+
+import (
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Soyunomas/taltun/pkg/pool"
+)
+
+// Parámetros del planificador.
+const (
+	// Quantum es lo que puede enviar un peer de peso 1 en cada turno del
+	// round robin. Grande para no romper las ráfagas que agrupa GSO.
+	Quantum = 16 << 10
+
+	MaxWeight = 100
+
+	// QueueBytes es la cola máxima por peer: lo que no cabe se descarta (antes
+	// lo de menos prioridad). MaxBytes acota la suma de todas las colas.
+	QueueBytes = 1 << 20
+	MaxBytes   = 32 << 20
+
+	// MaxLag es lo más que un paquete en cola puede quedarse por detrás (en
+	// contador de nonce, Packet.Seq) de otro del mismo peer que va a salir
+	// antes por ser de una clase más prioritaria. Por encima se envía primero
+	// el rezagado: el receptor descarta lo que cae fuera de su ventana
+	// anti-replay (2048), y la mitad deja margen al desorden del camino.
+	MaxLag = 1024
+
+	// El bucket admite ráfagas de burstTime a pleno caudal, y nunca menos que
+	// el mayor paquete.
+	burstTime = 20 * time.Millisecond
+	minBurst  = pool.MaxSize
+)
+
+// Packet es un mensaje ya cifrado a la espera de salir por UDP. Seq es el
+// contador de su nonce (crece por peer; 0 = sin orden que respetar).
+type Packet struct {
+	Data []byte
+	Buff *pool.Buff
+	Addr *net.UDPAddr
+	Seq  uint64
+}
+
+// Limits son los parámetros de un peer.
+type Limits struct {
+	Rate   uint64 // Bytes por segundo (0 = sin límite)
+	Weight int    // Peso en el reparto del enlace (1-MaxWeight)
+}
+
+// FlowStats son los contadores de un peer.
+type FlowStats struct {
+	Queued  int    // Bytes en cola
+	Shaped  uint64 // Paquetes retenidos por el límite de caudal
+	Dropped uint64 // Paquetes descartados con la cola llena
+}
+
+type queue struct {
+	pkts []Packet
+	head int
+}
+
+func (q *queue) len() int { return len(q.pkts) - q.head }
+
+func (q *queue) push(p Packet) {
+	if q.head > 0 && len(q.pkts) == cap(q.pkts) {
+		n := copy(q.pkts, q.pkts[q.head:])
+		clear(q.pkts[n:])
+		q.pkts, q.head = q.pkts[:n], 0
+	}
+	q.pkts = append(q.pkts, p)
+}
+
+func (q *queue) peek() *Packet { return &q.pkts[q.head] }
+
+func (q *queue) pop() Packet {
+	p := q.pkts[q.head]
+	q.pkts[q.head] = Packet{}
+	q.head++
+	if q.head == len(q.pkts) {
+		q.pkts, q.head = q.pkts[:0], 0
+	}
+	return p
+}
+
+func (q *queue) popTail() Packet {
+	n := len(q.pkts) - 1
+	p := q.pkts[n]
+	q.pkts[n] = Packet{}
+	q.pkts = q.pkts[:n]
+	if q.head == len(q.pkts) {
+		q.pkts, q.head = q.pkts[:0], 0
+	}
+	return p
+}
+
+// flow es la cola de un peer, una por clase, con su token bucket.
+type flow struct {
+	limits  Limits
+	quantum int
+	burst   float64
+	tokens  float64
+	last    time.Time
+
+	queues  [NumClasses]queue
+	deficit [NumClasses]int
+	bytes   int
+	stats   FlowStats
+}
+
+func (f *flow) setLimits(l Limits, now time.Time) {
+	l.Weight = min(max(l.Weight, 1), MaxWeight)
+	f.limits = l
+	f.quantum = l.Weight * Quantum
+	f.burst = max(float64(l.Rate)*burstTime.Seconds(), minBurst)
+	f.tokens = f.burst
+	f.last = now
+}
+
+// allow indica si el bucket tiene tokens para size bytes.
+func (f *flow) allow(size int, now time.Time) bool {
+	if f.limits.Rate == 0 {
+		return true
+	}
+	if now.After(f.last) {
+		f.tokens = min(f.burst, f.tokens+now.Sub(f.last).Seconds()*float64(f.limits.Rate))
+		f.last = now
+	}
+	return f.tokens >= float64(size)
+}
+
+// Scheduler reparte el enlace de salida entre peers: cada uno tiene su token
+// bucket (límite de caudal) y se turnan por deficit round robin según su peso.
+// Las clases se sirven en prioridad estricta, cada una con su propio turno,
+// salvo que una cola de un peer se quede más de MaxLag por detrás de otra.
+// Es seguro para uso concurrente.
+type Scheduler struct {
+	mu    sync.Mutex
+	flows map[uint32]*flow
+
+	// Peers con paquetes de cada clase, en orden de turno
+	rings   [NumClasses][]*flow
+	cur     [NumClasses]int
+	granted [NumClasses]bool // El peer en turno ya recibió su quantum
+
+	bytes int
+}
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{flows: make(map[uint32]*flow)}
+}
+
+// SetLimits fija el caudal y el peso de un peer.
+func (s *Scheduler) SetLimits(peer uint32, l Limits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flow(peer).setLimits(l, time.Now())
+}
+
+// Enqueue encola un paquete hacia peer. Devuelve false si se ha descartado
+// (su buffer ya está de vuelta en el pool). Con la cola llena se hace sitio
+// descartando primero lo último encolado de clases menos prioritarias.
+func (s *Scheduler) Enqueue(peer uint32, class Class, pkt Packet, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f := s.flow(peer)
+	size := len(pkt.Data)
+
+	if f.limits.Rate > 0 && !f.allow(f.bytes+size, now) {
+		f.stats.Shaped++
+	}
+
+	for f.bytes+size > QueueBytes || s.bytes+size > MaxBytes {
+		victim := -1
+		for c := NumClasses - 1; c > int(class); c-- {
+			if f.queues[c].len() > 0 {
+				victim = c
+				break
+			}
+		}
+		if victim < 0 {
+			pool.Put(pkt.Buff)
+			f.stats.Dropped++
+			return false
+		}
+		s.dropTail(f, Class(victim))
+	}
+
+	q := &f.queues[class]
+	if q.len() == 0 {
+		s.rings[class] = append(s.rings[class], f)
+	}
+	q.push(pkt)
+	f.bytes += size
+	s.bytes += size
+	return true
+}
+
+// Dequeue devuelve el siguiente paquete a enviar, o false si no hay ninguno
+// o todos esperan tokens (ver NextRelease).
+func (s *Scheduler) Dequeue(now time.Time) (Packet, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := Class(0); c < NumClasses; c++ {
+		if pkt, ok := s.dequeueClass(c, now); ok {
+			return pkt, true
+		}
+	}
+	return Packet{}, false
+}
+
+// NextRelease devuelve cuánto falta para que algún paquete retenido por su
+// límite de caudal pueda salir. false si no hay nada en cola.
+func (s *Scheduler) NextRelease(now time.Time) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var wait time.Duration
+	found := false
+	for c := range s.rings {
+		for _, f := range s.rings[c] {
+			size := len(f.queues[c].peek().Data)
+			d := time.Duration(0)
+			if !f.allow(size, now) {
+				d = time.Duration(math.Ceil((float64(size) - f.tokens) / float64(f.limits.Rate) * float64(time.Second)))
+			}
+			if !found || d < wait {
+				wait, found = d, true
+			}
+		}
+	}
+	return wait, found
+}
+
+// Stats devuelve los contadores de cada peer.
+func (s *Scheduler) Stats() map[uint32]FlowStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := make(map[uint32]FlowStats, len(s.flows))
+	for peer, f := range s.flows {
+		st := f.stats
+		st.Queued = f.bytes
+		m[peer] = st
+	}
+	return m
+}
+
+// flow devuelve la cola de un peer, creándola sin límites. Requiere s.mu.
+func (s *Scheduler) flow(peer uint32) *flow {
+	f := s.flows[peer]
+	if f == nil {
+		f = &flow{}
+		f.setLimits(Limits{}, time.Now())
+		s.flows[peer] = f
+	}
+	return f
+}
+
+// dequeueClass es un paso del deficit round robin de la clase c: el peer en
+// turno recibe su quantum al llegarle el turno y envía mientras le alcance.
+// Los peers sin tokens ceden el turno sin acumular déficit. Requiere s.mu.
+func (s *Scheduler) dequeueClass(c Class, now time.Time) (Packet, bool) {
+	for {
+		progress := false
+		for i := 0; i < len(s.rings[c]); i++ {
+			f := s.rings[c][s.cur[c]]
+			q := &f.queues[c]
+			size := len(q.peek().Data)
+
+			if !f.allow(size, now) {
+				s.next(c)
+				continue
+			}
+			progress = true
+			if !s.granted[c] {
+				f.deficit[c] += f.quantum
+				s.granted[c] = true
+			}
+			if f.deficit[c] < size {
+				s.next(c)
+				continue
+			}
+
+			// El turno es de la clase c, pero si otra cola del peer se ha
+			// quedado demasiado atrás sale antes su cabeza (a cuenta del turno).
+			from := f.lagging(c)
+			q = &f.queues[from]
+			pkt := q.pop()
+			size = len(pkt.Data)
+			f.deficit[c] -= size
+			if f.limits.Rate > 0 {
+				f.tokens -= float64(size)
+			}
+			f.bytes -= size
+			s.bytes -= size
+			if q.len() == 0 {
+				f.deficit[from] = 0
+				s.remove(from, f)
+			}
+			return pkt, true
+		}
+		if !progress {
+			return Packet{}, false
+		}
+	}
+}
+
+// lagging devuelve la clase de f cuya cabeza lleva más de MaxLag por detrás
+// de la cabeza de la clase c (la que más, si hay varias), o c si ninguna.
+func (f *flow) lagging(c Class) Class {
+	seq := f.queues[c].peek().Seq
+	lag, oldest := c, seq
+	for l := Class(0); l < NumClasses; l++ {
+		if l == c || f.queues[l].len() == 0 {
+			continue
+		}
+		if head := f.queues[l].peek().Seq; head != 0 && head+MaxLag < seq && head < oldest {
+			lag, oldest = l, head
+		}
+	}
+	return lag
+}
+
+// next pasa el turno de la clase c al siguiente peer. Requiere s.mu.
+func (s *Scheduler) next(c Class) {
+	s.cur[c] = (s.cur[c] + 1) % len(s.rings[c])
+	s.granted[c] = false
+}
+
+// remove saca a f del turno de la clase c (su cola quedó vacía). Requiere s.mu.
+func (s *Scheduler) remove(c Class, f *flow) {
+	ring := s.rings[c]
+	for i := range ring {
+		if ring[i] != f {
+			continue
+		}
+		copy(ring[i:], ring[i+1:])
+		ring[len(ring)-1] = nil
+		s.rings[c] = ring[:len(ring)-1]
+
+		switch {
+		case i < s.cur[c]:
+			s.cur[c]--
+		case i == s.cur[c]:
+			s.granted[c] = false
+		}
+		if s.cur[c] >= len(s.rings[c]) {
+			s.cur[c] = 0
+		}
+		return
+	}
+}
+
+// dropTail descarta el último paquete encolado de la clase c de f. Requiere s.mu.
+func (s *Scheduler) dropTail(f *flow, c Class) {
+	q := &f.queues[c]
+	pkt := q.popTail()
+	size := len(pkt.Data)
+	f.bytes -= size
+	s.bytes -= size
+	f.stats.Dropped++
+	pool.Put(pkt.Buff)
+	if q.len() == 0 {
+		f.deficit[c] = 0
+		s.remove(c, f)
+	}
+}
@@ -0,0 +1,159 @@
+package qos
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Soyunomas/taltun/pkg/pool"
+)
+
+func packet(size int, tag byte) Packet {
+	b := pool.GetSize(size)
+	b.B[0] = tag
+	return Packet{Data: b.B[:size], Buff: b}
+}
+
+func TestClassOf(t *testing.T) {
+	cases := []struct {
+		pkt  []byte
+		want Class
+	}{
+		{[]byte{0x45, 46 << 2}, ClassHigh}, // IPv4 EF
+		{[]byte{0x45, 8 << 2}, ClassBulk},  // IPv4 CS1
+		{[]byte{0x45, 0}, ClassNormal},     // IPv4 best effort
+		{[]byte{0x6b, 0x80}, ClassHigh},    // IPv6 EF (TC 0xb8)
+		{[]byte{0x60, 0x40}, ClassBulk},    // IPv6 LE (TC 0x04)
+	}
+	for _, c := range cases {
+		if got := ClassOf(c.pkt); got != c.want {
+			t.Errorf("ClassOf(% x) = %v, want %v", c.pkt, got, c.want)
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	for s, want := range map[string]uint64{"20mbit": 2.5e6, "512kbit": 64e3, "1.5Gbit": 187.5e6, "8000": 1000} {
+		if got, err := ParseRate(s); err != nil || got != want {
+			t.Errorf("ParseRate(%q) = %d, %v; want %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "fast", "-1mbit", "0"} {
+		if _, err := ParseRate(s); err == nil {
+			t.Errorf("ParseRate(%q) accepted", s)
+		}
+	}
+}
+
+func TestWeightedRoundRobin(t *testing.T) {
+	s := NewScheduler()
+	s.SetLimits(1, Limits{Weight: 1})
+	s.SetLimits(2, Limits{Weight: 3})
+	now := time.Now()
+
+	for i := 0; i < 400; i++ {
+		s.Enqueue(1, ClassNormal, packet(1024, 1), now)
+		s.Enqueue(2, ClassNormal, packet(1024, 2), now)
+	}
+	var sent [3]int
+	for i := 0; i < 320; i++ {
+		pkt, ok := s.Dequeue(now)
+		if !ok {
+			t.Fatalf("Dequeue %d: empty", i)
+		}
+		sent[pkt.Data[0]]++
+		pool.Put(pkt.Buff)
+	}
+	if sent[1] != 80 || sent[2] != 240 {
+		t.Errorf("Sent %d/%d packets, want 80/240", sent[1], sent[2])
+	}
+}
+
+func TestPriorityAndDrops(t *testing.T) {
+	s := NewScheduler()
+	now := time.Now()
+
+	// Cola llena de tráfico bulk: un paquete prioritario desplaza al último
+	n := QueueBytes / 1024
+	for i := 0; i < n; i++ {
+		s.Enqueue(1, ClassBulk, packet(1024, 0), now)
+	}
+	if s.Enqueue(1, ClassBulk, packet(1024, 0), now) {
+		t.Errorf("Bulk packet accepted with a full queue")
+	}
+	if !s.Enqueue(1, ClassHigh, packet(1024, 'h'), now) {
+		t.Fatalf("High priority packet dropped")
+	}
+	if pkt, _ := s.Dequeue(now); pkt.Data[0] != 'h' {
+		t.Errorf("High priority packet not sent first")
+	}
+	if st := s.Stats()[1]; st.Dropped != 2 || st.Queued != (n-1)*1024 {
+		t.Errorf("Stats = %+v, want 2 dropped, %d queued", st, (n-1)*1024)
+	}
+}
+
+func TestMaxLag(t *testing.T) {
+	s := NewScheduler()
+	now := time.Now()
+
+	// Un paquete bulk y detrás muchos prioritarios del mismo peer: el bulk
+	// espera, pero no tanto como para salirse de la ventana anti-replay.
+	bulk := packet(64, 'b')
+	bulk.Seq = 1
+	s.Enqueue(1, ClassBulk, bulk, now)
+	for seq := uint64(2); seq <= 2*MaxLag; seq++ {
+		pkt := packet(64, 'h')
+		pkt.Seq = seq
+		s.Enqueue(1, ClassHigh, pkt, now)
+	}
+
+	for i := 0; ; i++ {
+		pkt, ok := s.Dequeue(now)
+		if !ok {
+			t.Fatalf("Bulk packet never sent")
+		}
+		pool.Put(pkt.Buff)
+		if pkt.Data[0] == 'b' {
+			if i != MaxLag {
+				t.Errorf("Bulk packet sent after %d high priority packets, want %d", i, MaxLag)
+			}
+			break
+		}
+	}
+	// Y la clase prioritaria sigue donde estaba.
+	if pkt, _ := s.Dequeue(now); pkt.Seq != MaxLag+2 {
+		t.Errorf("Next packet seq %d, want %d", pkt.Seq, MaxLag+2)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	s := NewScheduler()
+	s.SetLimits(1, Limits{Rate: 1 << 20}) // 1 MB/s, ráfaga de minBurst
+	now := time.Now()
+
+	for i := 0; i < 128; i++ {
+		s.Enqueue(1, ClassNormal, packet(1024, 0), now)
+	}
+	sent := 0
+	for {
+		pkt, ok := s.Dequeue(now)
+		if !ok {
+			break
+		}
+		pool.Put(pkt.Buff)
+		sent++
+	}
+	if sent != minBurst/1024 {
+		t.Errorf("Sent %d packets in the burst, want %d", sent, minBurst/1024)
+	}
+
+	wait, ok := s.NextRelease(now)
+	if !ok || wait <= 0 || wait > time.Millisecond {
+		t.Fatalf("NextRelease = %v, %v", wait, ok)
+	}
+	if _, ok := s.Dequeue(now.Add(wait)); !ok {
+		t.Errorf("Packet not released after %v", wait)
+	}
+	if st := s.Stats()[1]; st.Shaped == 0 {
+		t.Errorf("No packets counted as shaped")
+	}
+}
@@ -0,0 +1,231 @@
+package transport
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Soyunomas/taltun/pkg/pool"
+)
+
+// Tamaño de la cola de envío por conexión (en mensajes).
+const sendQueueSize = 512
+
+// Tipos de frame que maneja el writer. Los transportes sin frames de control
+// (TCP) solo usan opData.
+const (
+	opData  uint8 = 0x2 // Coincide con el opcode "binary" de WebSocket
+	opClose uint8 = 0x8
+	opPing  uint8 = 0x9
+	opPong  uint8 = 0xA
+)
+
+type frame struct {
+	op  uint8
+	buf *pool.Buff
+	n   int
+}
+
+// frameCodec define cómo se delimitan los mensajes dentro del flujo.
+type frameCodec interface {
+	// readFrame lee un frame completo en dst y devuelve su tamaño y tipo.
+	readFrame(r *bufio.Reader, dst []byte) (n int, op uint8, err error)
+	writeFrame(w *bufio.Writer, op uint8, payload []byte) error
+}
+
+// streamConn implementa Conn sobre cualquier net.Conn orientado a flujo
+// (TCP, TLS...) delegando la delimitación de mensajes en un frameCodec.
+//
+// Las escrituras se encolan y un writer dedicado las vuelca con bufio, de
+// forma que un peer lento nunca bloquea el bucle de TX del engine y las
+// ráfagas se agrupan en pocas syscalls.
+type streamConn struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	codec  frameCodec
+	remote net.Addr // Dirección del cliente si difiere de la del socket (proxy)
+
+	sendCh    chan frame
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewStreamConn envuelve una conexión de flujo con el framing de Taltun:
+//
+//	Length (2) + Mensaje Taltun (Length)
+func NewStreamConn(c net.Conn) Conn {
+	return newStreamConn(c, nil, lengthPrefixCodec{})
+}
+
+// newStreamConn admite un bufio.Reader ya existente (p.ej. tras un handshake
+// HTTP que pudo leer de más).
+func newStreamConn(c net.Conn, r *bufio.Reader, codec frameCodec) *streamConn {
+	if r == nil {
+		r = bufio.NewReaderSize(c, 64*1024)
+	}
+	s := &streamConn{
+		conn:   c,
+		r:      r,
+		codec:  codec,
+		sendCh: make(chan frame, sendQueueSize),
+		done:   make(chan struct{}),
+	}
+	go s.writeLoop()
+	return s
+}
+
+func (s *streamConn) ReadPacket(dst []byte) (int, error) {
+	for {
+		n, op, err := s.codec.readFrame(s.r, dst)
+		if err != nil {
+			if err == ErrPacketTooLarge {
+				// Fuera de sincronía o peer malicioso: no hay forma de recuperarse.
+				s.Close()
+			}
+			return 0, err
+		}
+
+		switch op {
+		case opData:
+			return n, nil
+		case opPing:
+			s.enqueue(opPong, dst[:n])
+		case opClose:
+			s.Close()
+			return 0, io.EOF
+		}
+		// opPong y desconocidos: se ignoran
+	}
+}
+
+func (s *streamConn) WritePacket(pkt []byte) error {
+	return s.enqueue(opData, pkt)
+}
+
+func (s *streamConn) enqueue(op uint8, pkt []byte) error {
+	if len(pkt) > 0xFFFF {
+		return ErrPacketTooLarge
+	}
+
+	select {
+	case <-s.done:
+		return net.ErrClosed
+	default:
+	}
+
+	b := pool.GetSize(len(pkt)) // Keepalives y control no ocupan un buffer de MTU
+	n := copy(b.B, pkt)
+
+	select {
+	case s.sendCh <- frame{op: op, buf: b, n: n}:
+	default:
+		pool.Put(b) // Cola llena: descartamos como haría UDP
+	}
+	// Si se cerró mientras encolábamos, el writer puede haber vaciado ya la
+	// cola: el frame se devuelve aquí.
+	select {
+	case <-s.done:
+		s.drain()
+	default:
+	}
+	return nil
+}
+
+func (s *streamConn) writeLoop() {
+	w := bufio.NewWriterSize(s.conn, 64*1024)
+
+	defer s.drain()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case f := <-s.sendCh:
+			err := s.codec.writeFrame(w, f.op, f.buf.B[:f.n])
+			pool.Put(f.buf)
+
+			// Solo hacemos flush cuando la cola se vacía: agrupa ráfagas.
+			if err == nil && len(s.sendCh) == 0 {
+				err = w.Flush()
+			}
+			if err != nil {
+				s.Close()
+				return
+			}
+		}
+	}
+}
+
+// drain devuelve al pool los frames que quedaron en cola al cerrar.
+func (s *streamConn) drain() {
+	for {
+		select {
+		case f := <-s.sendCh:
+			pool.Put(f.buf)
+		default:
+			return
+		}
+	}
+}
+
+func (s *streamConn) SetReadDeadline(t time.Time) error {
+	return s.conn.SetReadDeadline(t)
+}
+
+func (s *streamConn) RemoteAddr() net.Addr {
+	if s.remote != nil {
+		return s.remote
+	}
+	return s.conn.RemoteAddr()
+}
+
+func (s *streamConn) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		err = s.conn.Close()
+	})
+	return err
+}
+
+// lengthPrefixCodec: cada mensaje va precedido de su longitud (uint16 big-endian).
+type lengthPrefixCodec struct{}
+
+func (lengthPrefixCodec) readFrame(r *bufio.Reader, dst []byte) (int, uint8, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, 0, err
+	}
+	n := int(binary.BigEndian.Uint16(hdr[:]))
+	if n > len(dst) {
+		return 0, 0, ErrPacketTooLarge
+	}
+	if _, err := io.ReadFull(r, dst[:n]); err != nil {
+		return 0, 0, err
+	}
+	return n, opData, nil
+}
+
+func (lengthPrefixCodec) writeFrame(w *bufio.Writer, op uint8, payload []byte) error {
+	var hdr [2]byte
+	binary.BigEndian.PutUint16(hdr[:], uint16(len(payload)))
+	w.Write(hdr[:])
+	_, err := w.Write(payload)
+	return err
+}
+
+// streamListener adapta un net.Listener para devolver Conns con framing.
+type streamListener struct {
+	net.Listener
+}
+
+func (l streamListener) Accept() (Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewStreamConn(c), nil
+}
@@ -0,0 +1,55 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// TCP transporta los mensajes sobre TCP con framing de longitud.
+// Útil en redes que bloquean UDP (hoteles, redes corporativas).
+const TCP = "tcp"
+
+type tcpTransport struct{}
+
+func init() {
+	Register(tcpTransport{})
+}
+
+func (tcpTransport) Name() string { return TCP }
+
+func (tcpTransport) Listen(addr string, opts Options) (Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return streamListener{l}, nil
+}
+
+func (tcpTransport) Dial(ctx context.Context, addr string, opts Options) (Conn, error) {
+	d := net.Dialer{Control: markControl(opts.FwMark)}
+	c, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewStreamConn(c), nil
+}
+
+// markControl aplica SO_MARK al socket antes de conectar.
+func markControl(fwmark uint32) func(network, address string, c syscall.RawConn) error {
+	if fwmark == 0 {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		var opErr error
+		err := c.Control(func(fd uintptr) {
+			opErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_MARK, int(fwmark))
+		})
+		if err != nil {
+			return err
+		}
+		return opErr
+	}
+}
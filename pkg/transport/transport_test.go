@@ -0,0 +1,86 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+// roundTrip conecta un cliente al listener y comprueba que los mensajes
+// llegan íntegros y separados en ambos sentidos.
+func roundTrip(t *testing.T, l Listener, dial func() (Conn, error)) {
+	t.Helper()
+
+	accepted := make(chan Conn, 1)
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			accepted <- c
+		}
+	}()
+
+	client, err := dial()
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+
+	var server Conn
+	select {
+	case server = <-accepted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Accept timeout")
+	}
+	defer server.Close()
+
+	msgs := [][]byte{
+		{0x01, 0xAA, 0xBB},
+		bytes.Repeat([]byte{0x03}, 1400),
+		{0x03}, // Mensaje mínimo
+	}
+	for _, m := range msgs {
+		if err := client.WritePacket(m); err != nil {
+			t.Fatalf("WritePacket failed: %v", err)
+		}
+	}
+
+	buf := make([]byte, 2048)
+	for i, want := range msgs {
+		n, err := server.ReadPacket(buf)
+		if err != nil {
+			t.Fatalf("ReadPacket %d failed: %v", i, err)
+		}
+		if !bytes.Equal(buf[:n], want) {
+			t.Errorf("Message %d corrupted: got %d bytes, want %d", i, n, len(want))
+		}
+	}
+
+	// Y de vuelta
+	if err := server.WritePacket([]byte("pong")); err != nil {
+		t.Fatal(err)
+	}
+	n, err := client.ReadPacket(buf)
+	if err != nil || string(buf[:n]) != "pong" {
+		t.Errorf("Reply failed: %q %v", buf[:n], err)
+	}
+}
+
+func TestTCPTransport(t *testing.T) {
+	tr, ok := Get(TCP)
+	if !ok {
+		t.Fatal("tcp transport not registered")
+	}
+
+	l, err := tr.Listen("127.0.0.1:0", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	roundTrip(t, l, func() (Conn, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return tr.Dial(ctx, l.Addr().String(), Options{})
+	})
+}
@@ -0,0 +1,456 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebSocket transporta cada mensaje Taltun en un frame binario WebSocket
+// (RFC 6455). Atraviesa proxies HTTP y CDNs que solo dejan pasar HTTPS.
+//
+//   - "wss": WebSocket sobre TLS (cliente y servidor).
+//   - "ws":  WebSocket en claro. En servidor, pensado para ir detrás de un
+//     reverse proxy (nginx, Caddy...) que termina TLS y reenvía el Upgrade.
+const (
+	WS  = "ws"
+	WSS = "wss"
+)
+
+// DefaultWSPath es la ruta HTTP del endpoint si Options.Path está vacío.
+const DefaultWSPath = "/taltun"
+
+// GUID fijo del RFC 6455 para calcular Sec-WebSocket-Accept.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const wsHandshakeTimeout = 10 * time.Second
+
+type wsTransport struct {
+	tls bool
+}
+
+func init() {
+	Register(wsTransport{tls: false})
+	Register(wsTransport{tls: true})
+}
+
+func (t wsTransport) Name() string {
+	if t.tls {
+		return WSS
+	}
+	return WS
+}
+
+// --- SERVIDOR ---
+
+type wsListener struct {
+	ln      net.Listener
+	srv     *http.Server
+	trusted []*net.IPNet
+	connCh  chan Conn
+	done    chan struct{}
+	once    sync.Once
+}
+
+func (t wsTransport) Listen(addr string, opts Options) (Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.tls {
+		if opts.TLSCert == "" || opts.TLSKey == "" {
+			ln.Close()
+			return nil, errors.New("wss requiere tls_cert y tls_key")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.TLSCert, opts.TLSKey)
+		if err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("error cargando certificado: %v", err)
+		}
+		ln = tls.NewListener(ln, &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+			NextProtos:   []string{"http/1.1"},
+		})
+	}
+
+	l := &wsListener{
+		ln:      ln,
+		trusted: opts.TrustedProxies,
+		connCh:  make(chan Conn),
+		done:    make(chan struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(wsPath(opts), l.handleUpgrade)
+	l.srv = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: wsHandshakeTimeout,
+	}
+	go l.srv.Serve(ln)
+
+	return l, nil
+}
+
+func (l *wsListener) handleUpgrade(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") ||
+		key == "" {
+		// A ojos de un escáner somos un servidor web cualquiera.
+		http.NotFound(w, r)
+		return
+	}
+	// Solo existe la versión 13 (RFC 6455 §4.4): se indica cuál hablamos.
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "unsupported", http.StatusInternalServerError)
+		return
+	}
+	c, rw, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	// http.Server dejó puestos sus deadlines de lectura de cabeceras.
+	c.SetDeadline(time.Time{})
+
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + wsAccept(key) + "\r\n\r\n"
+	if _, err := c.Write([]byte(resp)); err != nil {
+		c.Close()
+		return
+	}
+
+	conn := newStreamConn(c, rw.Reader, wsCodec{client: false})
+	conn.remote = l.clientAddr(c.RemoteAddr(), r.Header)
+	select {
+	case l.connCh <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+// clientAddr devuelve la dirección del cliente: la del socket, salvo que
+// venga de un proxy de confianza. Entonces es la última entrada de
+// X-Forwarded-For que no sea otro proxy de confianza (las anteriores las
+// pudo escribir el propio cliente). nil = la del socket.
+func (l *wsListener) clientAddr(peer net.Addr, h http.Header) net.Addr {
+	tcp, ok := peer.(*net.TCPAddr)
+	if !ok || !l.isTrusted(tcp.IP) {
+		return nil
+	}
+	var hops []string
+	for _, v := range h.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			return nil
+		}
+		if !l.isTrusted(ip) {
+			// El puerto del socket distingue conexiones del mismo cliente.
+			return &net.TCPAddr{IP: ip, Port: tcp.Port}
+		}
+	}
+	return nil
+}
+
+func (l *wsListener) isTrusted(ip net.IP) bool {
+	for _, n := range l.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *wsListener) Accept() (Conn, error) {
+	select {
+	case c := <-l.connCh:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *wsListener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+func (l *wsListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return l.srv.Close()
+}
+
+// --- CLIENTE ---
+
+func (t wsTransport) Dial(ctx context.Context, addr string, opts Options) (Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := dialThroughProxy(ctx, addr, opts, t.tls)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetDeadline(deadline)
+	} else {
+		c.SetDeadline(time.Now().Add(wsHandshakeTimeout))
+	}
+
+	if t.tls {
+		tc, err := tlsClientConfig(host, opts)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		tlsConn := tls.Client(c, tc)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("tls: %v", err)
+		}
+		c = tlsConn
+	}
+
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		c.Close()
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	req := "GET " + wsPath(opts) + " HTTP/1.1\r\n" +
+		"Host: " + addr + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := c.Write([]byte(req)); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReaderSize(c, 64*1024)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("websocket: respuesta inválida: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		resp.Header.Get("Sec-WebSocket-Accept") != wsAccept(key) {
+		c.Close()
+		return nil, fmt.Errorf("websocket: upgrade rechazado (%s)", resp.Status)
+	}
+
+	c.SetDeadline(time.Time{})
+	return newStreamConn(c, br, wsCodec{client: true}), nil
+}
+
+func tlsClientConfig(host string, opts Options) (*tls.Config, error) {
+	tc := &tls.Config{
+		ServerName:         host,
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: opts.TLSSkipVerify,
+		NextProtos:         []string{"http/1.1"},
+	}
+	if opts.TLSServerName != "" {
+		tc.ServerName = opts.TLSServerName
+	}
+	if opts.TLSCAFile != "" {
+		pem, err := os.ReadFile(opts.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error leyendo CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("tls_ca no contiene certificados PEM")
+		}
+		tc.RootCAs = pool
+	}
+	return tc, nil
+}
+
+// --- FRAMES (RFC 6455 §5.2) ---
+
+// wsCodec lee y escribe frames WebSocket. Los clientes enmascaran lo que
+// envían, los servidores no (lo exige el RFC).
+type wsCodec struct {
+	client bool
+}
+
+func (c wsCodec) readFrame(r *bufio.Reader, dst []byte) (int, uint8, error) {
+	total := 0
+	var msgOp uint8
+
+	for {
+		var hdr [2]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return 0, 0, err
+		}
+		fin := hdr[0]&0x80 != 0
+		op := hdr[0] & 0x0F
+		masked := hdr[1]&0x80 != 0
+
+		length := uint64(hdr[1] & 0x7F)
+		switch length {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(r, ext[:]); err != nil {
+				return 0, 0, err
+			}
+			length = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(r, ext[:]); err != nil {
+				return 0, 0, err
+			}
+			length = binary.BigEndian.Uint64(ext[:])
+		}
+
+		var mask [4]byte
+		if masked {
+			if _, err := io.ReadFull(r, mask[:]); err != nil {
+				return 0, 0, err
+			}
+		}
+
+		if length > uint64(len(dst)-total) {
+			return 0, 0, ErrPacketTooLarge
+		}
+		payload := dst[total : total+int(length)]
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return 0, 0, err
+		}
+		if masked {
+			for i := range payload {
+				payload[i] ^= mask[i%4]
+			}
+		}
+
+		// Frames de control (nunca fragmentados). El RFC permite intercalarlos
+		// en un mensaje fragmentado, pero Taltun nunca fragmenta: si ocurre lo
+		// tratamos como error de protocolo en vez de complicar el buffer.
+		if op >= opClose {
+			if total > 0 {
+				return 0, 0, errors.New("websocket: control frame inside fragmented message")
+			}
+			return int(length), op, nil
+		}
+
+		if op != 0 { // 0 = continuation
+			msgOp = op
+		}
+		total += int(length)
+		if fin {
+			if msgOp == 0x1 { // Texto: no lo usamos, lo tratamos como binario
+				msgOp = opData
+			}
+			return total, msgOp, nil
+		}
+	}
+}
+
+func (c wsCodec) writeFrame(w *bufio.Writer, op uint8, payload []byte) error {
+	var hdr [14]byte
+	hdr[0] = 0x80 | op // FIN + opcode
+	n := 2
+
+	l := len(payload)
+	switch {
+	case l < 126:
+		hdr[1] = byte(l)
+	case l <= 0xFFFF:
+		hdr[1] = 126
+		binary.BigEndian.PutUint16(hdr[2:], uint16(l))
+		n += 2
+	default:
+		hdr[1] = 127
+		binary.BigEndian.PutUint64(hdr[2:], uint64(l))
+		n += 8
+	}
+
+	if !c.client {
+		w.Write(hdr[:n])
+		_, err := w.Write(payload)
+		return err
+	}
+
+	hdr[1] |= 0x80
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return err
+	}
+	copy(hdr[n:], mask[:])
+	n += 4
+	w.Write(hdr[:n])
+
+	// Enmascaramos sobre un buffer temporal para no tocar el payload del llamante.
+	var tmp [512]byte
+	for off := 0; off < l; off += len(tmp) {
+		chunk := tmp[:min(len(tmp), l-off)]
+		for i := range chunk {
+			chunk[i] = payload[off+i] ^ mask[(off+i)%4]
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func wsAccept(key string) string {
+	h := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+func wsPath(opts Options) string {
+	if opts.Path == "" {
+		return DefaultWSPath
+	}
+	if !strings.HasPrefix(opts.Path, "/") {
+		return "/" + opts.Path
+	}
+	return opts.Path
+}
+
+// headerContains busca token (sin distinguir mayúsculas) en una cabecera con
+// valores separados por comas ("Connection: keep-alive, Upgrade").
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, part := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeSelfSigned genera un certificado autofirmado para 127.0.0.1 y devuelve
+// las rutas de cert y key (el cert sirve también como CA del cliente).
+func writeSelfSigned(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "taltun-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certFile, keyFile
+}
+
+func dialFunc(tr Transport, addr string, opts Options) func() (Conn, error) {
+	return func() (Conn, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return tr.Dial(ctx, addr, opts)
+	}
+}
+
+func TestWSSTransport(t *testing.T) {
+	certFile, keyFile := writeSelfSigned(t)
+	tr, _ := Get(WSS)
+
+	l, err := tr.Listen("127.0.0.1:0", Options{TLSCert: certFile, TLSKey: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	roundTrip(t, l, dialFunc(tr, l.Addr().String(), Options{TLSCAFile: certFile}))
+}
+
+// connectProxy es un proxy HTTP CONNECT mínimo para los tests.
+func connectProxy(t *testing.T) (addr string, used chan struct{}) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	used = make(chan struct{}, 1)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "only CONNECT", http.StatusMethodNotAllowed)
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		c, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+		c.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		select {
+		case used <- struct{}{}:
+		default:
+		}
+		go func() { io.Copy(upstream, c); upstream.Close() }()
+		go func() { io.Copy(c, upstream); c.Close() }()
+	})}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return ln.Addr().String(), used
+}
+
+func TestWSSThroughConnectProxy(t *testing.T) {
+	certFile, keyFile := writeSelfSigned(t)
+	tr, _ := Get(WSS)
+
+	l, err := tr.Listen("127.0.0.1:0", Options{TLSCert: certFile, TLSKey: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	proxyAddr, used := connectProxy(t)
+	opts := Options{TLSCAFile: certFile, Proxy: "http://" + proxyAddr}
+	roundTrip(t, l, dialFunc(tr, l.Addr().String(), opts))
+
+	select {
+	case <-used:
+	default:
+		t.Error("connection did not go through the proxy")
+	}
+}
+
+func TestWSBehindReverseProxy(t *testing.T) {
+	tr, _ := Get(WS)
+
+	l, err := tr.Listen("127.0.0.1:0", Options{Path: "/vpn"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// El reverse proxy reenvía el Upgrade al listener "ws" en claro.
+	backend, _ := url.Parse("http://" + l.Addr().String())
+	front := httptest.NewServer(httputil.NewSingleHostReverseProxy(backend))
+	defer front.Close()
+
+	frontAddr := front.Listener.Addr().String()
+	roundTrip(t, l, dialFunc(tr, frontAddr, Options{Path: "/vpn"}))
+}
+
+// rawUpgrade envía un Upgrade WebSocket a mano y devuelve el código de respuesta.
+func rawUpgrade(addr string, headers string) (int, error) {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	req := "GET /taltun HTTP/1.1\r\nHost: " + addr + "\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" + headers + "\r\n"
+	if _, err := c.Write([]byte(req)); err != nil {
+		return 0, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func TestWSForwardedFor(t *testing.T) {
+	tr, _ := Get(WS)
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	l, err := tr.Listen("127.0.0.1:0", Options{TrustedProxies: []*net.IPNet{loopback}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// La primera entrada la pudo inventar el cliente: vale la que añadió el proxy.
+	go rawUpgrade(l.Addr().String(), "Sec-WebSocket-Version: 13\r\nX-Forwarded-For: 203.0.113.9, 198.51.100.7\r\n")
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if ip := c.RemoteAddr().(*net.TCPAddr).IP; !ip.Equal(net.ParseIP("198.51.100.7")) {
+		t.Errorf("RemoteAddr = %v, want 198.51.100.7", c.RemoteAddr())
+	}
+
+	if code, err := rawUpgrade(l.Addr().String(), "Sec-WebSocket-Version: 8\r\n"); err != nil || code != http.StatusUpgradeRequired {
+		t.Errorf("Version 8 answered with %d (%v), want 426", code, err)
+	}
+}
@@ -16,6 +16,9 @@ NS_SERVER="ns-taltun-server"
 NS_CLIENT="ns-taltun-client"
 KEY_SERVER="1111111111111111111111111111111111111111111111111111111111111111"
 KEY_CLIENT="2222222222222222222222222222222222222222222222222222222222222222"
+# Colas de la TUN en ambos nodos (0 = una por core, 1 = sin multi-queue).
+# Para comparar: TUN_QUEUES=1 ./scripts/bench_throughput.sh
+TUN_QUEUES="${TUN_QUEUES:-0}"
 
 cleanup() {
     sudo killall vpn 2>/dev/null || true
@@ -41,6 +44,9 @@ sudo ip netns exec $NS_CLIENT ip link set lo up
 
 if [ ! -c /dev/net/tun ]; then sudo modprobe tun; fi
 
+# Clave pública del servidor: el cliente la necesita para firmar (mac1) su handshake
+PUB_SERVER=$(echo $KEY_SERVER | $BINARY pubkey)
+
 # 2. Start VPN
 echo "🔌 Iniciando Nodos con Profiling activo en Server..."
 # Servidor con PPROF habilitado en puerto 6060
@@ -48,6 +54,7 @@ sudo ip netns exec $NS_SERVER $BINARY \
     -mode server \
     -local "0.0.0.0:9000" \
     -tun tun0 \
+    -tun-queues $TUN_QUEUES \
     -key $KEY_SERVER \
     -vip "10.0.0.1" \
     -peer "10.0.0.2" \
@@ -59,9 +66,10 @@ sudo ip netns exec $NS_CLIENT $BINARY \
     -mode client \
     -local "0.0.0.0:9000" \
     -tun tun0 \
+    -tun-queues $TUN_QUEUES \
     -key $KEY_CLIENT \
     -vip "10.0.0.2" \
-    -peer "10.0.0.1,172.16.0.1:9000" \
+    -peer "10.0.0.1,172.16.0.1:9000,$PUB_SERVER" \
     > /dev/null 2>&1 &
 
 sleep 2
@@ -83,7 +91,7 @@ if ! sudo ip netns exec $NS_CLIENT ping -c 1 10.0.0.1 > /dev/null; then
     exit 1
 fi
 
-echo "🔥 INICIANDO BENCHMARK (10 segundos)..."
+echo "🔥 INICIANDO BENCHMARK (10 segundos, tun_queues=$TUN_QUEUES)..."
 echo "----------------------------------------"
 
 # Iniciar iperf3 server
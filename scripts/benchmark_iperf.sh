@@ -73,6 +73,9 @@ ip netns exec $NS_C ip link set lo up
 
 echo -e "${BLUE}🚀 Iniciando VPNs...${NC}"
 
+# Clave pública del servidor: el cliente la necesita para firmar (mac1) su handshake
+PUB_SERVER=$(echo $KEY | $BIN pubkey)
+
 # SERVIDOR
 # Escucha en 0.0.0.0:9000. Espera cliente 10.99.0.2 (IP dinámica inicial)
 ip netns exec $NS_S $BIN -mode server \
@@ -91,7 +94,7 @@ ip netns exec $NS_C $BIN -mode client \
     -remote "$PUB_IP_S:9000" \
     -tun tun0 \
     -key $KEY \
-    -peer "$VPN_IP_S,$PUB_IP_S:9000" \
+    -peer "$VPN_IP_S,$PUB_IP_S:9000,$PUB_SERVER" \
     > client.log 2>&1 &
 echo $! > client.pid
 
@@ -49,6 +49,9 @@ if [ ! -c /dev/net/tun ]; then
     exit 1
 fi
 
+# Clave pública del servidor: el cliente la necesita para firmar (mac1) su handshake
+PUB_SERVER=$(echo $KEY_SERVER | $BINARY pubkey)
+
 echo -e "${GREEN}[*] Start Server (VIP 10.0.0.1)...${NC}"
 # Agregado -debug y -vip
 sudo ip netns exec $NS_SERVER $BINARY \
@@ -79,7 +82,7 @@ sudo ip netns exec $NS_CLIENT $BINARY \
     -tun tun0 \
     -key $KEY_CLIENT \
     -vip "10.0.0.2" \
-    -peer "10.0.0.1,172.16.0.1:9000" \
+    -peer "10.0.0.1,172.16.0.1:9000,$PUB_SERVER" \
     -debug > client.log 2>&1 &
 PID_CLIENT=$!
 